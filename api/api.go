@@ -0,0 +1,68 @@
+// Package api serves the versioned JSON API under /api/v1. Handlers apply
+// the same rules as the HTML handlers in routes, but authenticate through
+// bearer tokens instead of the cookie session.
+package api
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+const pageSize = 10
+
+var secretKey []byte
+
+func init() {
+	godotenv.Load(".env")
+	secretKey = []byte(os.Getenv("SECRET_KEY"))
+}
+
+// Error is the body of every failed API response, wrapped in an "error" key
+type Error struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Error: Error{Status: status, Message: message},
+	})
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	middleware.SetRetryAfter(c, retryAfter)
+	abort(c, http.StatusTooManyRequests, "Too many attempts, try again later.")
+}
+
+// Return the authenticated user, or an empty string for anonymous requests
+func userId(c *gin.Context) string {
+	return c.GetString("userId")
+}
+
+func nextCursor[T interface{ Cursor() models.Cursor }](items []T, limit int) string {
+	return internal.NextCursor(items, limit, secretKey)
+}
+
+func pageCursor(c *gin.Context) (*models.Cursor, bool) {
+	token := c.Query("cursor")
+	if token == "" {
+		return nil, true
+	}
+	cursor, err := internal.DecodeCursor(token, secretKey)
+	if err != nil {
+		abort(c, http.StatusBadRequest, "Invalid cursor.")
+		return nil, false
+	}
+	return cursor, true
+}
+
+func NotFound(c *gin.Context) {
+	abort(c, http.StatusNotFound, "The requested resource was not found.")
+}
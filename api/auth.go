@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Read the user from the Authorization: Bearer header, which holds either
+// a session JWT or a personal access token. Session tokens are granted
+// every scope, access tokens only the ones they were created with.
+func authenticate(c *gin.Context, scopes []string) (string, int) {
+	store := database.Default(c)
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", http.StatusUnauthorized
+	}
+	raw := strings.TrimPrefix(header, "Bearer ")
+	if strings.HasPrefix(raw, middleware.AccessTokenPrefix) {
+		token := middleware.ParseAccessToken(store, header)
+		if token == nil {
+			return "", http.StatusUnauthorized
+		}
+		if !middleware.HasScopes(token, scopes) {
+			return "", http.StatusForbidden
+		}
+		return token.UserId, http.StatusOK
+	}
+	claims, err := middleware.ParseToken(store, raw)
+	if err != nil || claims.SessionId == "" {
+		return "", http.StatusUnauthorized
+	}
+	if user := store.ReadUserById(claims.UserId); user == nil {
+		return "", http.StatusUnauthorized
+	}
+	return claims.UserId, http.StatusOK
+}
+
+func AuthMiddleware(scopes ...string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id, status := authenticate(c, scopes)
+		switch status {
+		case http.StatusUnauthorized:
+			c.Header("WWW-Authenticate", `Bearer realm="tsuki"`)
+			abort(c, status, "Missing or invalid bearer token.")
+			return
+		case http.StatusForbidden:
+			abort(c, status, "Access token does not have the required scope.")
+			return
+		}
+		c.Set("userId", id)
+		c.Next()
+	}
+}
+
+// Identify the user if a token with the read scope is sent, for endpoints
+// that are public but include viewer specific fields
+func OptionalAuthMiddleware() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if id, status := authenticate(c, []string{models.ScopeRead}); status == http.StatusOK {
+			c.Set("userId", id)
+		}
+		c.Next()
+	}
+}
+
+func CreateToken(c *gin.Context) {
+	store := database.Default(c)
+	var login Credentials
+	if err := c.ShouldBindJSON(&login); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Shares the buckets of the login form
+	if ok, retryAfter := middleware.Throttle(c, "login-ip", middleware.LoginIPLimit, c.ClientIP()); !ok {
+		tooManyRequests(c, retryAfter)
+		return
+	}
+	if ok, retryAfter := middleware.Throttle(c, "login-user", middleware.LoginUsernameLimit, strings.ToLower(login.Username)); !ok {
+		tooManyRequests(c, retryAfter)
+		return
+	}
+	user := store.ReadUserByName(login.Username)
+	if user == nil {
+		abort(c, http.StatusUnauthorized, "Incorrect username or password.")
+		return
+	}
+	if locked, retryAfter := middleware.LockedOut(c, user.Id); locked {
+		tooManyRequests(c, retryAfter)
+		return
+	}
+	if !user.CheckPassword(login.Password) {
+		middleware.LoginFailed(c, user)
+		abort(c, http.StatusUnauthorized, "Incorrect username or password.")
+		return
+	}
+	if middleware.TwoFactorEnabled(store, user.Id) && !middleware.CheckTwoFactor(store, user.Id, login.Code) {
+		middleware.LoginFailed(c, user)
+		abort(c, http.StatusUnauthorized, "Missing or incorrect two-factor code.")
+		return
+	}
+	middleware.LoginSucceeded(c, user.Id)
+	tokens, err := middleware.NewSession(store, user.Id, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		abort(c, http.StatusInternalServerError, "Unable to create token, try again later.")
+		return
+	}
+	c.JSON(http.StatusCreated, newToken(tokens))
+}
+
+func newToken(tokens *middleware.SessionTokens) Token {
+	return Token{
+		Token:        tokens.Access,
+		RefreshToken: tokens.Refresh,
+		ExpiresIn:    int(middleware.AccessTokenLifetime.Seconds()),
+	}
+}
+
+// RefreshToken rotates a refresh token, the old one stops working
+func RefreshToken(c *gin.Context) {
+	store := database.Default(c)
+	var refresh Refresh
+	if err := c.ShouldBindJSON(&refresh); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	tokens, err := middleware.RefreshSession(store, refresh.RefreshToken, c.ClientIP())
+	// Within the grace period the client already holds the rotated token
+	if err != nil || tokens.Refresh == "" {
+		abort(c, http.StatusUnauthorized, "Invalid or expired refresh token.")
+		return
+	}
+	c.JSON(http.StatusOK, newToken(tokens))
+}
+
+// RevokeToken ends the session of a refresh token
+func RevokeToken(c *gin.Context) {
+	store := database.Default(c)
+	var refresh Refresh
+	if err := c.ShouldBindJSON(&refresh); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !middleware.RevokeRefreshToken(store, refresh.RefreshToken) {
+		abort(c, http.StatusUnauthorized, "Invalid or expired refresh token.")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
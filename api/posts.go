@@ -0,0 +1,267 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Fill in the author fields that are not stored with the post
+func withAuthors(store database.Store, posts []models.Post) []models.Post {
+	for index := range posts {
+		if author := store.ReadUserById(posts[index].UserId); author != nil {
+			posts[index].Username = author.Username
+			posts[index].Avatar = author.Avatar
+		}
+	}
+	if posts == nil {
+		return []models.Post{}
+	}
+	return posts
+}
+
+func withCommenters(store database.Store, viewerId string, comments []models.Comment) []models.Comment {
+	for index := range comments {
+		if author := store.ReadUserById(comments[index].UserId); author != nil {
+			comments[index].Username = author.Username
+		}
+		comments[index].Self = viewerId != "" && viewerId == comments[index].UserId
+	}
+	if comments == nil {
+		return []models.Comment{}
+	}
+	return comments
+}
+
+// Resolve the :id parameter, aborting with 404 if the post doesn't exist
+func paramPost(c *gin.Context) *models.Post {
+	post := database.Default(c).ReadPost(c.Param("id"))
+	if post == nil {
+		abort(c, http.StatusNotFound, "Post not found.")
+	}
+	return post
+}
+
+func GetUserPosts(c *gin.Context) {
+	store := database.Default(c)
+	user := paramUser(c)
+	if user == nil {
+		return
+	}
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	posts := store.ReadPosts(user.Id, pageSize, cursor)
+	c.JSON(http.StatusOK, PostPage{
+		Posts:  withAuthors(store, posts),
+		Cursor: nextCursor(posts, pageSize),
+	})
+}
+
+func GetFeed(c *gin.Context) {
+	store := database.Default(c)
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	posts := store.ReadFeedPosts(userId(c), pageSize, cursor)
+	c.JSON(http.StatusOK, PostPage{
+		Posts:  withAuthors(store, posts),
+		Cursor: nextCursor(posts, pageSize),
+	})
+}
+
+func CreatePost(c *gin.Context) {
+	store := database.Default(c)
+	var body NewPost
+	if err := c.ShouldBindJSON(&body); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	post := models.Post{
+		Id:        uuid.NewString(),
+		Body:      body.Body,
+		CreatedAt: time.Now(),
+	}
+	if result := store.CreatePost(userId(c), &post); !result {
+		abort(c, http.StatusInternalServerError, "Unable to create post, try again later.")
+		return
+	}
+	created := store.ReadPost(post.Id)
+	c.JSON(http.StatusCreated, withAuthors(store, []models.Post{*created})[0])
+}
+
+func GetPost(c *gin.Context) {
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	c.JSON(http.StatusOK, withAuthors(database.Default(c), []models.Post{*post})[0])
+}
+
+// EditPost replaces the body of a post within the edit window after posting
+func EditPost(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	if post.UserId != userId(c) {
+		abort(c, http.StatusForbidden, "Cannot perform this task.")
+		return
+	}
+	if !post.Editable(internal.PostEditWindow(), time.Now()) {
+		abort(c, http.StatusForbidden, "This post can no longer be edited.")
+		return
+	}
+	var body PostEdit
+	if err := c.ShouldBindJSON(&body); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Body != post.Body {
+		if result := store.UpdatePost(post.Id, body.Body, uuid.NewString(), time.Now()); !result {
+			abort(c, http.StatusInternalServerError, "Unable to edit post, try again later.")
+			return
+		}
+	}
+	edited := store.ReadPost(post.Id)
+	c.JSON(http.StatusOK, withAuthors(store, []models.Post{*edited})[0])
+}
+
+func GetRevisions(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	revisions := store.ReadRevisions(post.Id)
+	if revisions == nil {
+		revisions = []models.Revision{}
+	}
+	c.JSON(http.StatusOK, Revisions{Revisions: revisions})
+}
+
+func DeletePost(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	if post.UserId != userId(c) {
+		abort(c, http.StatusForbidden, "Cannot perform this task.")
+		return
+	}
+	if result := store.DeletePost(post.Id); !result {
+		abort(c, http.StatusInternalServerError, "Unable to delete post, try again later.")
+		return
+	}
+	media.DeleteUnused(c, post.Media, store)
+	c.Status(http.StatusNoContent)
+}
+
+func GetVotes(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	voters := store.ReadVotes(post.Id)
+	if voters == nil {
+		voters = []string{}
+	}
+	votes := Votes{Voters: voters}
+	if userId(c) != "" {
+		voted := store.Voted(userId(c), post.Id)
+		votes.Voted = &voted
+	}
+	c.JSON(http.StatusOK, votes)
+}
+
+// setVote adds or removes the vote of the user on the :id post, both are
+// idempotent
+func setVote(c *gin.Context, vote bool) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	if store.Voted(userId(c), post.Id) != vote {
+		store.ToggleVote(userId(c), post.Id)
+	}
+	GetVotes(c)
+}
+
+func Vote(c *gin.Context) {
+	setVote(c, true)
+}
+
+func Unvote(c *gin.Context) {
+	setVote(c, false)
+}
+
+func GetComments(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	comments := store.ReadComments(post.Id, pageSize, cursor)
+	c.JSON(http.StatusOK, CommentPage{
+		Comments: withCommenters(store, userId(c), comments),
+		Cursor:   nextCursor(comments, pageSize),
+	})
+}
+
+func CreateComment(c *gin.Context) {
+	store := database.Default(c)
+	post := paramPost(c)
+	if post == nil {
+		return
+	}
+	var body NewComment
+	if err := c.ShouldBindJSON(&body); err != nil {
+		abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	comment := models.Comment{
+		Id:        uuid.NewString(),
+		Body:      body.Body,
+		CreatedAt: time.Now(),
+	}
+	if result := store.CreateComment(userId(c), post.Id, &comment); !result {
+		abort(c, http.StatusInternalServerError, "Unable to add comment, try again later.")
+		return
+	}
+	created := store.ReadComment(comment.Id)
+	c.JSON(http.StatusCreated, withCommenters(store, userId(c), []models.Comment{*created})[0])
+}
+
+func DeleteComment(c *gin.Context) {
+	store := database.Default(c)
+	comment := store.ReadComment(c.Param("commentId"))
+	if comment == nil || comment.PostId != c.Param("id") {
+		abort(c, http.StatusNotFound, "Comment not found.")
+		return
+	}
+	if comment.UserId != userId(c) {
+		abort(c, http.StatusForbidden, "Cannot perform this task.")
+		return
+	}
+	if result := store.DeleteComment(comment.Id); !result {
+		abort(c, http.StatusInternalServerError, "Unable to delete comment, try again later.")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
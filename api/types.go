@@ -0,0 +1,76 @@
+package api
+
+import "github.com/Devansh3712/tsuki-go/models"
+
+// Request and response bodies of the JSON endpoints, also used to generate
+// the OpenAPI document
+
+// Code is required for accounts with two-factor authentication, it takes a
+// TOTP code or a recovery code
+type Credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code,omitempty"`
+}
+
+type NewPost struct {
+	Body string `json:"body" binding:"required,max=320"`
+}
+
+type PostEdit struct {
+	Body string `json:"body" binding:"required,max=320"`
+}
+
+type NewComment struct {
+	Body string `json:"body" binding:"required,max=320"`
+}
+
+type Refresh struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Token is a short-lived access token, exchange the refresh token at
+// /api/v1/auth/refresh for a new pair before it expires
+type Token struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type PostPage struct {
+	Posts  []models.Post `json:"posts"`
+	Cursor string        `json:"cursor"`
+}
+
+type CommentPage struct {
+	Comments []models.Comment `json:"comments"`
+	Cursor   string           `json:"cursor"`
+}
+
+type ProfilePage struct {
+	Users  []Profile `json:"users"`
+	Cursor string    `json:"cursor"`
+}
+
+// Revisions are the previous bodies of a post, newest first
+type Revisions struct {
+	Revisions []models.Revision `json:"revisions"`
+}
+
+// Voted is only set for authenticated requests
+type Votes struct {
+	Voters []string `json:"voters"`
+	Voted  *bool    `json:"voted,omitempty"`
+}
+
+type Followers struct {
+	Followers []string `json:"followers"`
+}
+
+type Following struct {
+	Following []string `json:"following"`
+}
+
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Profile is a user with their counts, Follows is only set when the request
+// is authenticated as another user
+type Profile struct {
+	models.User
+	Followers int
+	Following int
+	Posts     int
+	Follows   *bool
+}
+
+func NewProfile(store database.Store, viewerId string, user models.User) Profile {
+	// Emails are private to their owner
+	if viewerId != user.Id {
+		user.Email = nil
+	}
+	profile := Profile{
+		User:      user,
+		Followers: store.ReadFollowersCount(user.Id),
+		Following: store.ReadFollowingCount(user.Id),
+		Posts:     store.ReadPostsCount(user.Id),
+	}
+	if viewerId != "" && viewerId != user.Id {
+		follows := store.Followed(viewerId, user.Id)
+		profile.Follows = &follows
+	}
+	return profile
+}
+
+// Resolve the :username parameter, aborting with 404 if it doesn't exist
+func paramUser(c *gin.Context) *models.User {
+	user := database.Default(c).ReadUserByName(c.Param("username"))
+	if user == nil {
+		abort(c, http.StatusNotFound, "User not found.")
+	}
+	return user
+}
+
+func GetCurrentUser(c *gin.Context) {
+	store := database.Default(c)
+	user := store.ReadUserById(userId(c))
+	if user == nil {
+		abort(c, http.StatusNotFound, "User not found.")
+		return
+	}
+	c.JSON(http.StatusOK, NewProfile(store, userId(c), *user))
+}
+
+func GetUser(c *gin.Context) {
+	user := paramUser(c)
+	if user == nil {
+		return
+	}
+	c.JSON(http.StatusOK, NewProfile(database.Default(c), userId(c), *user))
+}
+
+func SearchUsers(c *gin.Context) {
+	store := database.Default(c)
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	result := store.ReadUsers(c.Query("q"), pageSize, cursor)
+	users := []Profile{}
+	for _, user := range result {
+		users = append(users, NewProfile(store, userId(c), user))
+	}
+	c.JSON(http.StatusOK, ProfilePage{
+		Users:  users,
+		Cursor: nextCursor(result, pageSize),
+	})
+}
+
+func GetFollowers(c *gin.Context) {
+	user := paramUser(c)
+	if user == nil {
+		return
+	}
+	followers := database.Default(c).ReadFollowers(user.Id)
+	if followers == nil {
+		followers = []string{}
+	}
+	c.JSON(http.StatusOK, Followers{Followers: followers})
+}
+
+func GetFollowing(c *gin.Context) {
+	user := paramUser(c)
+	if user == nil {
+		return
+	}
+	following := database.Default(c).ReadFollowing(user.Id)
+	if following == nil {
+		following = []string{}
+	}
+	c.JSON(http.StatusOK, Following{Following: following})
+}
+
+// setFollow follows or unfollows the :username user, both are idempotent
+func setFollow(c *gin.Context, follow bool) {
+	store := database.Default(c)
+	user := paramUser(c)
+	if user == nil {
+		return
+	}
+	if user.Id == userId(c) {
+		abort(c, http.StatusForbidden, "Cannot follow yourself.")
+		return
+	}
+	if store.Followed(userId(c), user.Id) != follow {
+		store.ToggleFollow(userId(c), user.Id)
+	}
+	c.JSON(http.StatusOK, NewProfile(store, userId(c), *user))
+}
+
+func Follow(c *gin.Context) {
+	setFollow(c, true)
+}
+
+func Unfollow(c *gin.Context) {
+	setFollow(c, false)
+}
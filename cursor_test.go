@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Cursors that weren't signed by this server are refused by the pages and
+// the API alike
+func TestInvalidCursorRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := database.NewMemoryStore()
+	if !store.CreateUser(&models.User{Id: "alice-0000-0000-0000-000000000000", Username: "alice", CreatedAt: time.Now()}) {
+		t.Fatal("unable to create user")
+	}
+	app := newRouter(store, database.NewMemoryRateLimitStore(), media.NewLocalStore(t.TempDir(), "/media"))
+
+	cursor := models.Cursor{CreatedAt: time.Now(), Id: "post"}
+	valid := internal.EncodeCursor(cursor, []byte(os.Getenv("SECRET_KEY")))
+	payload, signature, _ := strings.Cut(valid, ".")
+	tokens := map[string]string{
+		"tampered":    payload[:len(payload)-2] + "AA." + signature,
+		"truncated":   valid[:len(valid)-5],
+		"foreign key": internal.EncodeCursor(cursor, []byte("another server")),
+	}
+	for _, path := range []string{"/user/alice/posts/more", "/api/v1/users/alice/posts"} {
+		get := func(token string) int {
+			response := httptest.NewRecorder()
+			app.ServeHTTP(response, httptest.NewRequest("GET", path+"?cursor="+url.QueryEscape(token), nil))
+			return response.Code
+		}
+		if status := get(valid); status != http.StatusOK {
+			t.Errorf("%s: valid cursor got status %d, want 200", path, status)
+		}
+		for name, token := range tokens {
+			if status := get(token); status != http.StatusBadRequest {
+				t.Errorf("%s: %s cursor got status %d, want 400", path, name, status)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+func (s *PostgresStore) CreateIdentity(identity *models.Identity) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO identities(provider, subject, user_id, email, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		identity.Provider,
+		identity.Subject,
+		identity.UserId,
+		identity.Email,
+		identity.CreatedAt,
+		identity.LastUsedAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) ReadIdentity(provider string, subject string) *models.Identity {
+	var identity models.Identity
+	if err := s.db.QueryRow(
+		`SELECT provider, subject, user_id, email, created_at, last_used_at
+		FROM identities WHERE provider = $1 AND subject = $2`,
+		provider, subject,
+	).Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserId,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastUsedAt,
+	); err != nil {
+		return nil
+	}
+	return &identity
+}
+
+func (s *PostgresStore) ReadIdentities(userId string) []models.Identity {
+	var identities []models.Identity
+	rows, err := s.db.Query(
+		`SELECT provider, subject, user_id, email, created_at, last_used_at
+		FROM identities WHERE user_id = $1 ORDER BY created_at`,
+		userId,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(
+			&identity.Provider,
+			&identity.Subject,
+			&identity.UserId,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastUsedAt,
+		); err != nil {
+			log.Println(err)
+			return nil
+		}
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
+func (s *PostgresStore) TouchIdentity(provider string, subject string, usedAt time.Time) {
+	if _, err := s.db.Exec(
+		`UPDATE identities SET last_used_at = $3 WHERE provider = $1 AND subject = $2`,
+		provider, subject, usedAt,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) DeleteIdentity(userId string, provider string) bool {
+	result, err := s.db.Exec(
+		`DELETE FROM identities WHERE user_id = $1 AND provider = $2`,
+		userId, provider,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows > 0
+}
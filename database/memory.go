@@ -0,0 +1,1244 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+type follow struct {
+	userId   string
+	followId string
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type verification struct {
+	token   string
+	userId  string
+	purpose string
+}
+
+type vote struct {
+	userId string
+	id     string
+}
+
+// MemoryStore keeps all data in maps guarded by a mutex. It mirrors the
+// constraints of the migrations (unique usernames, emails and ids, cascading
+// deletes) so handlers behave the same as with PostgresStore.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	mediaMu       sync.Mutex
+	users         map[string]models.User
+	verifications map[string]verification
+	posts         map[string]models.Post
+	revisions     map[string][]models.Revision
+	follows       map[follow]bool
+	votes         map[vote]bool
+	comments      map[string]models.Comment
+	accessTokens  map[string]models.AccessToken
+	sessions      map[string]models.Session
+	twoFactor     map[string]models.TwoFactor
+	recoveryCodes map[string]map[string]bool
+	passkeys      map[string]models.Passkey
+	outbox        map[string]models.Mail
+	identities    map[identityKey]models.Identity
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         make(map[string]models.User),
+		verifications: make(map[string]verification),
+		posts:         make(map[string]models.Post),
+		revisions:     make(map[string][]models.Revision),
+		follows:       make(map[follow]bool),
+		votes:         make(map[vote]bool),
+		comments:      make(map[string]models.Comment),
+		accessTokens:  make(map[string]models.AccessToken),
+		sessions:      make(map[string]models.Session),
+		twoFactor:     make(map[string]models.TwoFactor),
+		recoveryCodes: make(map[string]map[string]bool),
+		passkeys:      make(map[string]models.Passkey),
+		outbox:        make(map[string]models.Mail),
+		identities:    make(map[identityKey]models.Identity),
+	}
+}
+
+// paginate sorts items newest first and returns the page after the cursor
+func paginate[T interface{ Cursor() models.Cursor }](items []T, limit int, cursor *models.Cursor) []T {
+	sort.Slice(items, func(i, j int) bool {
+		position := items[i].Cursor()
+		return position.Precedes(items[j].Cursor())
+	})
+	var page []T
+	for _, item := range items {
+		if len(page) == limit {
+			break
+		}
+		if cursor.Precedes(item.Cursor()) {
+			page = append(page, item)
+		}
+	}
+	return page
+}
+
+func (s *MemoryStore) CreateUser(user *models.User) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Id]; ok {
+		return false
+	}
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return false
+		}
+		if existing.Email != nil && user.Email != nil && *existing.Email == *user.Email {
+			return false
+		}
+	}
+	s.users[user.Id] = *user
+	return true
+}
+
+func (s *MemoryStore) findUser(match func(user *models.User) bool) *models.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if match(&user) {
+			return &user
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReadUserByName(username string) *models.User {
+	return s.findUser(func(user *models.User) bool {
+		return user.Username == username
+	})
+}
+
+func (s *MemoryStore) ReadUserByEmail(email string) *models.User {
+	return s.findUser(func(user *models.User) bool {
+		return user.Email != nil && *user.Email == email
+	})
+}
+
+func (s *MemoryStore) ReadUserById(id string) *models.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	return &user
+}
+
+func (s *MemoryStore) ReadUsers(username string, limit int, cursor *models.Cursor) []models.User {
+	s.mu.RLock()
+	var users []models.User
+	for _, user := range s.users {
+		if strings.Contains(user.Username, username) {
+			users = append(users, user)
+		}
+	}
+	s.mu.RUnlock()
+	return paginate(users, limit, cursor)
+}
+
+func toStringPointer(value any) *string {
+	switch value := value.(type) {
+	case string:
+		return &value
+	case *string:
+		return value
+	default:
+		return nil
+	}
+}
+
+func (s *MemoryStore) UpdateUser(id string, updates map[string]any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return true
+	}
+	for column, value := range updates {
+		switch column {
+		case "email":
+			email := toStringPointer(value)
+			for _, existing := range s.users {
+				if email != nil && existing.Id != id && existing.Email != nil && *existing.Email == *email {
+					return false
+				}
+			}
+			user.Email = email
+		case "username":
+			username, _ := value.(string)
+			for _, existing := range s.users {
+				if existing.Id != id && existing.Username == username {
+					return false
+				}
+			}
+			user.Username = username
+		case "password":
+			user.Password, _ = value.(string)
+		case "verified":
+			user.Verified, _ = value.(bool)
+		case "avatar":
+			user.Avatar = toStringPointer(value)
+		case "avatar_medium":
+			user.AvatarMedium = toStringPointer(value)
+		case "avatar_small":
+			user.AvatarSmall = toStringPointer(value)
+		default:
+			return false
+		}
+	}
+	// The changes are made on a copy, so a rejected column leaves the user
+	// as it was
+	s.users[id] = user
+	return true
+}
+
+func (s *MemoryStore) AvatarInUse(url string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		for _, avatar := range []*string{user.Avatar, user.AvatarMedium, user.AvatarSmall} {
+			if avatar != nil && *avatar == url {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) DeleteUser(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	for verificationId, verification := range s.verifications {
+		if verification.userId == id {
+			delete(s.verifications, verificationId)
+		}
+	}
+	for postId, post := range s.posts {
+		if post.UserId == id {
+			s.deletePost(postId)
+		}
+	}
+	for key := range s.follows {
+		if key.userId == id || key.followId == id {
+			delete(s.follows, key)
+		}
+	}
+	for key := range s.votes {
+		if key.userId == id {
+			delete(s.votes, key)
+		}
+	}
+	for commentId, comment := range s.comments {
+		if comment.UserId == id {
+			delete(s.comments, commentId)
+		}
+	}
+	for tokenId, token := range s.accessTokens {
+		if token.UserId == id {
+			delete(s.accessTokens, tokenId)
+		}
+	}
+	for sessionId, session := range s.sessions {
+		if session.UserId == id {
+			delete(s.sessions, sessionId)
+		}
+	}
+	delete(s.twoFactor, id)
+	delete(s.recoveryCodes, id)
+	for passkeyId, passkey := range s.passkeys {
+		if passkey.UserId == id {
+			delete(s.passkeys, passkeyId)
+		}
+	}
+	for key, identity := range s.identities {
+		if identity.UserId == id {
+			delete(s.identities, key)
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) Followed(userId string, followId string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.follows[follow{userId, followId}]
+}
+
+func (s *MemoryStore) ToggleFollow(userId string, followId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := follow{userId, followId}
+	if s.follows[key] {
+		delete(s.follows, key)
+		return
+	}
+	_, userExists := s.users[userId]
+	_, followExists := s.users[followId]
+	if userExists && followExists {
+		s.follows[key] = true
+	}
+}
+
+func (s *MemoryStore) usernames(match func(key follow) (string, bool)) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var usernames []string
+	for key := range s.follows {
+		if id, ok := match(key); ok {
+			usernames = append(usernames, s.users[id].Username)
+		}
+	}
+	return usernames
+}
+
+func (s *MemoryStore) ReadFollowers(userId string) []string {
+	return s.usernames(func(key follow) (string, bool) {
+		return key.userId, key.followId == userId
+	})
+}
+
+func (s *MemoryStore) ReadFollowersCount(userId string) int {
+	return len(s.ReadFollowers(userId))
+}
+
+func (s *MemoryStore) ReadFollowing(userId string) []string {
+	return s.usernames(func(key follow) (string, bool) {
+		return key.followId, key.userId == userId
+	})
+}
+
+func (s *MemoryStore) ReadFollowingCount(userId string) int {
+	return len(s.ReadFollowing(userId))
+}
+
+func (s *MemoryStore) CreateVerificationId(token string, id string, userId string, purpose string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userId]; !ok {
+		return false
+	}
+	if _, ok := s.verifications[id]; ok {
+		return false
+	}
+	for _, existing := range s.verifications {
+		if existing.token == token {
+			return false
+		}
+	}
+	s.verifications[id] = verification{token, userId, purpose}
+	return true
+}
+
+func (s *MemoryStore) ReadVerificationId(id string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.verifications[id].token
+}
+
+func (s *MemoryStore) DeleteVerificationId(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.verifications[id]; !ok {
+		return false
+	}
+	delete(s.verifications, id)
+	return true
+}
+
+func (s *MemoryStore) DeleteVerificationIds(userId string, purposes []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existing := range s.verifications {
+		if existing.userId != userId {
+			continue
+		}
+		for _, purpose := range purposes {
+			if existing.purpose == purpose {
+				delete(s.verifications, id)
+				break
+			}
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) CreatePost(userId string, post *models.Post) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userId]; !ok {
+		return false
+	}
+	if _, ok := s.posts[post.Id]; ok {
+		return false
+	}
+	var media []models.Media
+	for _, item := range post.Media {
+		item.PostId = post.Id
+		media = append(media, item)
+	}
+	s.posts[post.Id] = models.Post{
+		UserId:    userId,
+		Id:        post.Id,
+		Body:      post.Body,
+		Media:     media,
+		CreatedAt: post.CreatedAt,
+	}
+	return true
+}
+
+func (s *MemoryStore) ReadPost(id string) *models.Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return nil
+	}
+	return &post
+}
+
+func (s *MemoryStore) ReadPostsCount(userId string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count int
+	for _, post := range s.posts {
+		if post.UserId == userId {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStore) filterPosts(match func(post *models.Post) bool, limit int, cursor *models.Cursor) []models.Post {
+	s.mu.RLock()
+	var posts []models.Post
+	for _, post := range s.posts {
+		if match(&post) {
+			posts = append(posts, post)
+		}
+	}
+	s.mu.RUnlock()
+	return paginate(posts, limit, cursor)
+}
+
+func (s *MemoryStore) ReadPosts(userId string, limit int, cursor *models.Cursor) []models.Post {
+	return s.filterPosts(func(post *models.Post) bool {
+		return post.UserId == userId
+	}, limit, cursor)
+}
+
+func (s *MemoryStore) ReadFeedPosts(userId string, limit int, cursor *models.Cursor) []models.Post {
+	return s.filterPosts(func(post *models.Post) bool {
+		return s.follows[follow{userId, post.UserId}]
+	}, limit, cursor)
+}
+
+// deletePost removes a post with its votes and comments, the caller must
+// hold the write lock
+func (s *MemoryStore) deletePost(id string) {
+	delete(s.posts, id)
+	delete(s.revisions, id)
+	for key := range s.votes {
+		if key.id == id {
+			delete(s.votes, key)
+		}
+	}
+	for commentId, comment := range s.comments {
+		if comment.PostId == id {
+			delete(s.comments, commentId)
+		}
+	}
+}
+
+func (s *MemoryStore) DeletePost(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deletePost(id)
+	return true
+}
+
+func (s *MemoryStore) UpdatePost(id string, body string, revisionId string, editedAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return false
+	}
+	writtenAt := post.CreatedAt
+	if post.EditedAt != nil {
+		writtenAt = *post.EditedAt
+	}
+	s.revisions[id] = append(s.revisions[id], models.Revision{
+		PostId:     id,
+		Id:         revisionId,
+		Body:       post.Body,
+		CreatedAt:  writtenAt,
+		ReplacedAt: editedAt,
+	})
+	post.Body = body
+	post.EditedAt = &editedAt
+	s.posts[id] = post
+	return true
+}
+
+func (s *MemoryStore) ReadRevisions(postId string) []models.Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var revisions []models.Revision
+	// Stored oldest first
+	for index := len(s.revisions[postId]) - 1; index >= 0; index-- {
+		revisions = append(revisions, s.revisions[postId][index])
+	}
+	return revisions
+}
+
+func (s *MemoryStore) ReadUserMedia(userId string) []models.Media {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var media []models.Media
+	for _, post := range s.posts {
+		if post.UserId == userId {
+			media = append(media, post.Media...)
+		}
+	}
+	return media
+}
+
+func (s *MemoryStore) MediaInUse(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, post := range s.posts {
+		for _, item := range post.Media {
+			if item.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LockMedia holds a single lock for every key, the memory store only
+// serves one instance
+func (s *MemoryStore) LockMedia(keys []string) (func(), bool) {
+	s.mediaMu.Lock()
+	return s.mediaMu.Unlock, true
+}
+
+func (s *MemoryStore) Voted(userId string, id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.votes[vote{userId, id}]
+}
+
+func (s *MemoryStore) ToggleVote(userId string, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := vote{userId, id}
+	if s.votes[key] {
+		delete(s.votes, key)
+		return
+	}
+	_, userExists := s.users[userId]
+	_, postExists := s.posts[id]
+	if userExists && postExists {
+		s.votes[key] = true
+	}
+}
+
+func (s *MemoryStore) ReadVotes(id string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var voters []string
+	for key := range s.votes {
+		if key.id == id {
+			voters = append(voters, s.users[key.userId].Username)
+		}
+	}
+	return voters
+}
+
+func (s *MemoryStore) CreateComment(userId string, postId string, comment *models.Comment) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, userExists := s.users[userId]
+	_, postExists := s.posts[postId]
+	if !userExists || !postExists {
+		return false
+	}
+	if _, ok := s.comments[comment.Id]; ok {
+		return false
+	}
+	s.comments[comment.Id] = models.Comment{
+		UserId:    userId,
+		PostId:    postId,
+		Id:        comment.Id,
+		Body:      comment.Body,
+		CreatedAt: comment.CreatedAt,
+	}
+	return true
+}
+
+func (s *MemoryStore) ReadComment(id string) *models.Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return nil
+	}
+	return &comment
+}
+
+func (s *MemoryStore) ReadComments(postId string, limit int, cursor *models.Cursor) []models.Comment {
+	s.mu.RLock()
+	var comments []models.Comment
+	for _, comment := range s.comments {
+		if comment.PostId == postId {
+			comments = append(comments, comment)
+		}
+	}
+	s.mu.RUnlock()
+	return paginate(comments, limit, cursor)
+}
+
+func (s *MemoryStore) DeleteComment(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.comments, id)
+	return true
+}
+
+func (s *MemoryStore) CreateAccessToken(token *models.AccessToken) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[token.UserId]; !ok {
+		return false
+	}
+	for _, existing := range s.accessTokens {
+		if existing.Id == token.Id || existing.Hash == token.Hash {
+			return false
+		}
+	}
+	created := *token
+	created.Scopes = append([]string(nil), token.Scopes...)
+	s.accessTokens[token.Id] = created
+	return true
+}
+
+func (s *MemoryStore) ReadAccessTokenByHash(hash string) *models.AccessToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, token := range s.accessTokens {
+		if token.Hash == hash {
+			return &token
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReadAccessTokens(userId string) []models.AccessToken {
+	s.mu.RLock()
+	var tokens []models.AccessToken
+	for _, token := range s.accessTokens {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+func (s *MemoryStore) TouchAccessToken(id string, usedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token, ok := s.accessTokens[id]; ok {
+		token.LastUsedAt = &usedAt
+		s.accessTokens[id] = token
+	}
+}
+
+func (s *MemoryStore) DeleteAccessToken(userId string, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token, ok := s.accessTokens[id]; !ok || token.UserId != userId {
+		return false
+	}
+	delete(s.accessTokens, id)
+	return true
+}
+
+func (s *MemoryStore) DeleteAccessTokens(userId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.accessTokens {
+		if token.UserId == userId {
+			delete(s.accessTokens, id)
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) CreateSession(session *models.Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[session.UserId]; !ok {
+		return false
+	}
+	for _, existing := range s.sessions {
+		if existing.Id == session.Id || existing.RefreshHash == session.RefreshHash {
+			return false
+		}
+	}
+	s.sessions[session.Id] = *session
+	return true
+}
+
+func (s *MemoryStore) ReadSession(id string) *models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	return &session
+}
+
+func (s *MemoryStore) ReadSessions(userId string) []models.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	var sessions []models.Session
+	for _, session := range s.sessions {
+		if session.UserId == userId && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions
+}
+
+func (s *MemoryStore) RotateSession(id string, hash string, newHash string, ip string, rotatedAt time.Time, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.RefreshHash != hash || session.RevokedAt != nil {
+		return false
+	}
+	session.PreviousHash = &hash
+	session.RefreshHash = newHash
+	session.IP = ip
+	session.LastSeenAt = rotatedAt
+	session.RotatedAt = rotatedAt
+	session.ExpiresAt = expiresAt
+	s.sessions[id] = session
+	return true
+}
+
+func (s *MemoryStore) UsePreviousHash(id string, hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.PreviousHash == nil || *session.PreviousHash != hash || session.RevokedAt != nil {
+		return false
+	}
+	session.PreviousHash = nil
+	s.sessions[id] = session
+	return true
+}
+
+func (s *MemoryStore) TouchSession(id string, seenAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok {
+		session.LastSeenAt = seenAt
+		s.sessions[id] = session
+	}
+}
+
+func (s *MemoryStore) RevokeSession(userId string, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.UserId != userId || session.RevokedAt != nil {
+		return false
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	s.sessions[id] = session
+	return true
+}
+
+func (s *MemoryStore) RevokeSessions(userId string, exceptId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, session := range s.sessions {
+		if session.UserId == userId && id != exceptId && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			s.sessions[id] = session
+		}
+	}
+	return true
+}
+
+func (s *MemoryStore) CreateTwoFactor(twoFactor *models.TwoFactor) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[twoFactor.UserId]; !ok {
+		return false
+	}
+	if existing, ok := s.twoFactor[twoFactor.UserId]; ok && existing.Enabled {
+		return false
+	}
+	s.twoFactor[twoFactor.UserId] = models.TwoFactor{
+		UserId:    twoFactor.UserId,
+		Secret:    twoFactor.Secret,
+		CreatedAt: twoFactor.CreatedAt,
+	}
+	return true
+}
+
+func (s *MemoryStore) ReadTwoFactor(userId string) *models.TwoFactor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	twoFactor, ok := s.twoFactor[userId]
+	if !ok {
+		return nil
+	}
+	return &twoFactor
+}
+
+// Map of unused recovery code hashes to false, used ones to true
+func recoveryCodes(codeHashes []string) map[string]bool {
+	codes := make(map[string]bool)
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	return codes
+}
+
+func (s *MemoryStore) EnableTwoFactor(userId string, step int64, codeHashes []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twoFactor, ok := s.twoFactor[userId]
+	if !ok || twoFactor.Enabled {
+		return false
+	}
+	twoFactor.Enabled = true
+	twoFactor.LastStep = step
+	s.twoFactor[userId] = twoFactor
+	s.recoveryCodes[userId] = recoveryCodes(codeHashes)
+	return true
+}
+
+func (s *MemoryStore) UseTOTPStep(userId string, step int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	twoFactor, ok := s.twoFactor[userId]
+	if !ok || twoFactor.LastStep >= step {
+		return false
+	}
+	twoFactor.LastStep = step
+	s.twoFactor[userId] = twoFactor
+	return true
+}
+
+func (s *MemoryStore) UseRecoveryCode(userId string, codeHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, ok := s.recoveryCodes[userId][codeHash]
+	if !ok || used {
+		return false
+	}
+	s.recoveryCodes[userId][codeHash] = true
+	return true
+}
+
+func (s *MemoryStore) ReadRecoveryCodesCount(userId string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, used := range s.recoveryCodes[userId] {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(userId string, codeHashes []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recoveryCodes[userId] = recoveryCodes(codeHashes)
+	return true
+}
+
+func (s *MemoryStore) DeleteTwoFactor(userId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.twoFactor, userId)
+	delete(s.recoveryCodes, userId)
+	return true
+}
+
+func (s *MemoryStore) CreatePasskey(passkey *models.Passkey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[passkey.UserId]; !ok {
+		return false
+	}
+	if _, ok := s.passkeys[passkey.Id]; ok {
+		return false
+	}
+	s.passkeys[passkey.Id] = *passkey
+	return true
+}
+
+func (s *MemoryStore) ReadPasskey(id string) *models.Passkey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	passkey, ok := s.passkeys[id]
+	if !ok {
+		return nil
+	}
+	return &passkey
+}
+
+func (s *MemoryStore) ReadPasskeys(userId string) []models.Passkey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var passkeys []models.Passkey
+	for _, passkey := range s.passkeys {
+		if passkey.UserId == userId {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool {
+		return passkeys[i].CreatedAt.After(passkeys[j].CreatedAt)
+	})
+	return passkeys
+}
+
+func (s *MemoryStore) TouchPasskey(id string, credential webauthn.Credential, usedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if passkey, ok := s.passkeys[id]; ok {
+		passkey.Credential = credential
+		passkey.LastUsedAt = &usedAt
+		s.passkeys[id] = passkey
+	}
+}
+
+func (s *MemoryStore) DeletePasskey(userId string, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if passkey, ok := s.passkeys[id]; !ok || passkey.UserId != userId {
+		return false
+	}
+	delete(s.passkeys, id)
+	return true
+}
+
+func (s *MemoryStore) EnqueueMail(mail *models.Mail) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mail.DedupKey != nil {
+		for id, queued := range s.outbox {
+			if queued.Pending() && queued.DedupKey != nil && *queued.DedupKey == *mail.DedupKey {
+				if queued.ClaimedUntil != nil && !queued.ClaimedUntil.Before(mail.CreatedAt) {
+					return true
+				}
+				queued.Recipient = mail.Recipient
+				queued.Subject = mail.Subject
+				queued.HTML = mail.HTML
+				queued.Text = mail.Text
+				queued.Attempts = 0
+				queued.LastError = nil
+				queued.NextAttemptAt = mail.NextAttemptAt
+				queued.Version++
+				s.outbox[id] = queued
+				return true
+			}
+		}
+	}
+	if _, ok := s.outbox[mail.Id]; ok {
+		return false
+	}
+	s.outbox[mail.Id] = *mail
+	return true
+}
+
+func (s *MemoryStore) ClaimMail(now time.Time, lease time.Duration, limit int) []models.Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var mails []models.Mail
+	for _, mail := range s.outbox {
+		if mail.Pending() && !mail.NextAttemptAt.After(now) {
+			mails = append(mails, mail)
+		}
+	}
+	sort.Slice(mails, func(i, j int) bool {
+		return mails[i].NextAttemptAt.Before(mails[j].NextAttemptAt)
+	})
+	if len(mails) > limit {
+		mails = mails[:limit]
+	}
+	claimedUntil := now.Add(lease)
+	for i := range mails {
+		mails[i].Attempts++
+		mails[i].NextAttemptAt = claimedUntil
+		mails[i].ClaimedUntil = &claimedUntil
+		s.outbox[mails[i].Id] = mails[i]
+	}
+	return mails
+}
+
+func (s *MemoryStore) MarkMailSent(id string, version int, sentAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mail, ok := s.outbox[id]; ok && mail.Version == version {
+		mail.SentAt = &sentAt
+		mail.LastError = nil
+		mail.ClaimedUntil = nil
+		s.outbox[id] = mail
+	}
+}
+
+func (s *MemoryStore) MarkMailFailed(id string, version int, lastError string, nextAttemptAt time.Time, dead bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if mail, ok := s.outbox[id]; ok && mail.Version == version {
+		mail.LastError = &lastError
+		mail.NextAttemptAt = nextAttemptAt
+		mail.ClaimedUntil = nil
+		if dead {
+			now := time.Now()
+			mail.DeadAt = &now
+		}
+		s.outbox[id] = mail
+	}
+}
+
+func (s *MemoryStore) ReadDeadMail(limit int) []models.Mail {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var mails []models.Mail
+	for _, mail := range s.outbox {
+		if mail.DeadAt != nil {
+			mails = append(mails, mail)
+		}
+	}
+	sort.Slice(mails, func(i, j int) bool {
+		return mails[i].DeadAt.After(*mails[j].DeadAt)
+	})
+	if len(mails) > limit {
+		mails = mails[:limit]
+	}
+	return mails
+}
+
+func (s *MemoryStore) RetryMail(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mail, ok := s.outbox[id]
+	if !ok || mail.DeadAt == nil {
+		return false
+	}
+	if mail.DedupKey != nil {
+		for _, queued := range s.outbox {
+			if queued.Pending() && queued.DedupKey != nil && *queued.DedupKey == *mail.DedupKey {
+				return false
+			}
+		}
+	}
+	mail.DeadAt = nil
+	mail.Attempts = 0
+	mail.NextAttemptAt = now
+	mail.ClaimedUntil = nil
+	s.outbox[id] = mail
+	return true
+}
+
+func (s *MemoryStore) CreateIdentity(identity *models.Identity) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[identity.UserId]; !ok {
+		return false
+	}
+	for key, linked := range s.identities {
+		if key == (identityKey{identity.Provider, identity.Subject}) ||
+			(linked.UserId == identity.UserId && linked.Provider == identity.Provider) {
+			return false
+		}
+	}
+	s.identities[identityKey{identity.Provider, identity.Subject}] = *identity
+	return true
+}
+
+func (s *MemoryStore) ReadIdentity(provider string, subject string) *models.Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identity, ok := s.identities[identityKey{provider, subject}]
+	if !ok {
+		return nil
+	}
+	return &identity
+}
+
+func (s *MemoryStore) ReadIdentities(userId string) []models.Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var identities []models.Identity
+	for _, identity := range s.identities {
+		if identity.UserId == userId {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+	return identities
+}
+
+func (s *MemoryStore) TouchIdentity(provider string, subject string, usedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := identityKey{provider, subject}
+	if identity, ok := s.identities[key]; ok {
+		identity.LastUsedAt = &usedAt
+		s.identities[key] = identity
+	}
+}
+
+func (s *MemoryStore) DeleteIdentity(userId string, provider string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, identity := range s.identities {
+		if identity.UserId == userId && identity.Provider == provider {
+			delete(s.identities, key)
+			return true
+		}
+	}
+	return false
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimitStore keeps rate limits and lockouts of a single instance
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	buckets  map[string]bucket
+	lockouts map[string]models.Lockout
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:  make(map[string]bucket),
+		lockouts: make(map[string]models.Lockout),
+	}
+}
+
+func (s *MemoryRateLimitStore) TakeToken(key string, limit models.RateLimit, now time.Time) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.buckets[key]
+	tokens := float64(limit.Burst)
+	if ok {
+		elapsed := now.Sub(current.updatedAt)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		tokens = math.Min(tokens, current.tokens+float64(elapsed)/float64(limit.Interval))
+	}
+	if tokens < 1 {
+		return false, time.Duration((1 - tokens) * float64(limit.Interval))
+	}
+	s.buckets[key] = bucket{tokens: tokens - 1, updatedAt: now}
+	return true, 0
+}
+
+func (s *MemoryRateLimitStore) PruneRateLimits(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, bucket := range s.buckets {
+		if bucket.updatedAt.Before(before) {
+			delete(s.buckets, key)
+		}
+	}
+	for userId, lockout := range s.lockouts {
+		if lockout.FirstFailedAt.Before(before) && (lockout.LockedUntil == nil || lockout.LockedUntil.Before(before)) {
+			delete(s.lockouts, userId)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) ReadLockout(userId string) *models.Lockout {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockout, ok := s.lockouts[userId]
+	if !ok {
+		return nil
+	}
+	return &lockout
+}
+
+func (s *MemoryRateLimitStore) RecordLoginFailure(userId string, now time.Time, window time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockout, ok := s.lockouts[userId]
+	if !ok {
+		lockout = models.Lockout{UserId: userId, FirstFailedAt: now}
+	}
+	if lockout.FirstFailedAt.Before(now.Add(-window)) {
+		lockout.Failures = 0
+		lockout.FirstFailedAt = now
+	}
+	lockout.Failures++
+	s.lockouts[userId] = lockout
+	return lockout.Failures
+}
+
+func (s *MemoryRateLimitStore) LockUser(userId string, now time.Time, until time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockout, ok := s.lockouts[userId]
+	if !ok || lockout.Locked(now) {
+		return false
+	}
+	lockout.Failures = 0
+	lockout.FirstFailedAt = now
+	lockout.LockedUntil = &until
+	s.lockouts[userId] = lockout
+	return true
+}
+
+func (s *MemoryRateLimitStore) DeleteLockout(userId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lockouts, userId)
+}
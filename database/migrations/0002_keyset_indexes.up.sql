@@ -0,0 +1,8 @@
+CREATE INDEX IF NOT EXISTS posts_user_id_created_at_idx
+    ON posts (user_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_created_at_idx
+    ON comments (post_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS t_users_created_at_idx
+    ON t_users (created_at DESC, id DESC);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id             CHAR(36)        PRIMARY KEY,
+    user_id        CHAR(36)        NOT NULL,
+    refresh_hash   CHAR(64)        UNIQUE NOT NULL,
+    previous_hash  CHAR(64),
+    user_agent     TEXT            NOT NULL,
+    ip             VARCHAR(45)     NOT NULL,
+    created_at     TIMESTAMPTZ     NOT NULL,
+    last_seen_at   TIMESTAMPTZ     NOT NULL,
+    rotated_at     TIMESTAMPTZ     NOT NULL,
+    expires_at     TIMESTAMPTZ     NOT NULL,
+    revoked_at     TIMESTAMPTZ,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES t_users(id)
+            ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions(user_id);
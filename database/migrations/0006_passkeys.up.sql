@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id            TEXT            PRIMARY KEY,
+    user_id       CHAR(36)        NOT NULL,
+    name          VARCHAR(64)     NOT NULL,
+    credential    JSONB           NOT NULL,
+    created_at    TIMESTAMPTZ     NOT NULL,
+    last_used_at  TIMESTAMPTZ,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES t_users(id)
+            ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS passkeys_user_id ON passkeys(user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id               CHAR(36)        PRIMARY KEY,
+    recipient        TEXT            NOT NULL,
+    subject          TEXT            NOT NULL,
+    html             TEXT            NOT NULL,
+    dedup_key        TEXT,
+    attempts         INTEGER         NOT NULL DEFAULT 0,
+    last_error       TEXT,
+    created_at       TIMESTAMPTZ     NOT NULL,
+    next_attempt_at  TIMESTAMPTZ     NOT NULL,
+    sent_at          TIMESTAMPTZ,
+    dead_at          TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox(next_attempt_at)
+    WHERE sent_at IS NULL AND dead_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_dedup_key ON outbox(dedup_key)
+    WHERE sent_at IS NULL AND dead_at IS NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS identities (
+    provider      VARCHAR(32)     NOT NULL,
+    subject       TEXT            NOT NULL,
+    user_id       CHAR(36)        NOT NULL,
+    email         TEXT,
+    created_at    TIMESTAMPTZ     NOT NULL,
+    last_used_at  TIMESTAMPTZ,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (user_id, provider),
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES t_users(id)
+            ON DELETE CASCADE
+);
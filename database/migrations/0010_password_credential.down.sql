@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS o_users (
+    id          CHAR(36)        PRIMARY KEY,
+    CONSTRAINT fk_id
+        FOREIGN KEY(id)
+            REFERENCES t_users(id)
+            ON DELETE CASCADE
+);
+INSERT INTO o_users(id) SELECT id FROM t_users WHERE password IS NULL;
+UPDATE t_users SET password = '' WHERE password IS NULL;
+ALTER TABLE t_users ALTER COLUMN password SET NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE t_users ALTER COLUMN password DROP NOT NULL;
+-- Accounts created through OAuth only had a random password nobody knows
+UPDATE t_users SET password = NULL WHERE id IN (SELECT id FROM o_users);
+DROP TABLE IF EXISTS o_users;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key           TEXT                PRIMARY KEY,
+    tokens        DOUBLE PRECISION    NOT NULL,
+    updated_at    TIMESTAMPTZ         NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_updated_at ON rate_limits (updated_at);
+
+CREATE TABLE IF NOT EXISTS lockouts (
+    user_id          CHAR(36)       PRIMARY KEY,
+    failures         INTEGER        NOT NULL,
+    first_failed_at  TIMESTAMPTZ    NOT NULL,
+    locked_until     TIMESTAMPTZ,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES t_users(id)
+            ON DELETE CASCADE
+);
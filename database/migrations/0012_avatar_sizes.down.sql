@@ -0,0 +1,3 @@
+ALTER TABLE t_users
+    DROP COLUMN IF EXISTS avatar_small,
+    DROP COLUMN IF EXISTS avatar_medium;
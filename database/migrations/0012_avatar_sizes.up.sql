@@ -0,0 +1,6 @@
+ALTER TABLE t_users
+    ADD COLUMN IF NOT EXISTS avatar_medium TEXT,
+    ADD COLUMN IF NOT EXISTS avatar_small  TEXT;
+
+-- Earlier avatars were uploaded in a single size
+UPDATE t_users SET avatar_medium = avatar, avatar_small = avatar WHERE avatar IS NOT NULL;
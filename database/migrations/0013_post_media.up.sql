@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS post_media (
+    post_id       CHAR(36)    NOT NULL,
+    position      SMALLINT    NOT NULL CHECK (position BETWEEN 0 AND 3),
+    key           TEXT        NOT NULL,
+    url           TEXT        NOT NULL,
+    content_type  TEXT        NOT NULL,
+    alt           TEXT        NOT NULL DEFAULT '',
+    PRIMARY KEY (post_id, position),
+    CONSTRAINT fk_post_id
+        FOREIGN KEY(post_id)
+            REFERENCES posts(id)
+            ON DELETE CASCADE
+);
+
+-- Blobs are shared by posts attaching the same image
+CREATE INDEX IF NOT EXISTS post_media_key ON post_media (key);
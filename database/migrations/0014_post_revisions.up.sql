@@ -0,0 +1,16 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+
+-- Every body a post had before an edit, created_at is when it was written
+CREATE TABLE IF NOT EXISTS post_revisions (
+    post_id      CHAR(36)        NOT NULL,
+    id           CHAR(36)        PRIMARY KEY,
+    body         VARCHAR(320)    NOT NULL,
+    created_at   TIMESTAMPTZ     NOT NULL,
+    replaced_at  TIMESTAMPTZ     NOT NULL,
+    CONSTRAINT fk_post_id
+        FOREIGN KEY(post_id)
+            REFERENCES posts(id)
+            ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_revisions_post_id ON post_revisions (post_id, replaced_at);
@@ -0,0 +1 @@
+DROP VIEW IF EXISTS dead_letters;
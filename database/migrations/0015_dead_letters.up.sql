@@ -0,0 +1,4 @@
+-- Mails that ran out of attempts, they stay in the outbox so RetryMail can
+-- queue them again
+CREATE OR REPLACE VIEW dead_letters AS
+    SELECT * FROM outbox WHERE dead_at IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS dead_letters;
+ALTER TABLE outbox DROP COLUMN IF EXISTS claimed_until;
+ALTER TABLE outbox DROP COLUMN IF EXISTS version;
+CREATE VIEW dead_letters AS
+    SELECT * FROM outbox WHERE dead_at IS NOT NULL;
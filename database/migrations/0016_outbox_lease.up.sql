@@ -0,0 +1,8 @@
+-- claimed_until is when the lease of the worker sending the mail runs out,
+-- version counts the replacements of its content
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
+
+-- The view lists the columns of the outbox when it is created
+CREATE OR REPLACE VIEW dead_letters AS
+    SELECT * FROM outbox WHERE dead_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS shorturl_user_id;
+ALTER TABLE shorturl DROP COLUMN IF EXISTS purpose;
+ALTER TABLE shorturl DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,6 @@
+-- Owner and purpose of a mailed link, so the pending links of a user can be
+-- revoked. Links created before are left without them until they expire.
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS user_id CHAR(36)
+    REFERENCES t_users(id) ON DELETE CASCADE;
+ALTER TABLE shorturl ADD COLUMN IF NOT EXISTS purpose TEXT;
+CREATE INDEX IF NOT EXISTS shorturl_user_id ON shorturl (user_id, purpose);
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+const mailColumns = `id, recipient, subject, html, text, dedup_key, attempts, last_error,
+	created_at, next_attempt_at, sent_at, dead_at, version, claimed_until`
+
+func scanMail(rows *sql.Rows) (models.Mail, error) {
+	var mail models.Mail
+	err := rows.Scan(
+		&mail.Id,
+		&mail.Recipient,
+		&mail.Subject,
+		&mail.HTML,
+		&mail.Text,
+		&mail.DedupKey,
+		&mail.Attempts,
+		&mail.LastError,
+		&mail.CreatedAt,
+		&mail.NextAttemptAt,
+		&mail.SentAt,
+		&mail.DeadAt,
+		&mail.Version,
+		&mail.ClaimedUntil,
+	)
+	return mail, err
+}
+
+func (s *PostgresStore) readMail(query string, args ...any) []models.Mail {
+	var mails []models.Mail
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		mail, err := scanMail(rows)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		mails = append(mails, mail)
+	}
+	return mails
+}
+
+// EnqueueMail replaces a pending mail with the same dedup key instead of
+// queueing another one, the replaced mail starts over with its attempts.
+// A mail claimed by a worker is left alone, it is sent with the old content.
+func (s *PostgresStore) EnqueueMail(mail *models.Mail) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO outbox(id, recipient, subject, html, text, dedup_key, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (dedup_key) WHERE sent_at IS NULL AND dead_at IS NULL
+		DO UPDATE SET recipient = EXCLUDED.recipient, subject = EXCLUDED.subject,
+		html = EXCLUDED.html, text = EXCLUDED.text, attempts = 0, last_error = NULL,
+		next_attempt_at = EXCLUDED.next_attempt_at, version = outbox.version + 1
+		WHERE outbox.claimed_until IS NULL OR outbox.claimed_until < EXCLUDED.created_at`,
+		mail.Id,
+		mail.Recipient,
+		mail.Subject,
+		mail.HTML,
+		mail.Text,
+		mail.DedupKey,
+		mail.CreatedAt,
+		mail.NextAttemptAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) ClaimMail(now time.Time, lease time.Duration, limit int) []models.Mail {
+	return s.readMail(
+		`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2, claimed_until = $2
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+mailColumns,
+		now, now.Add(lease), limit,
+	)
+}
+
+// MarkMailSent and MarkMailFailed only change the mail if its content is
+// still the claimed version
+func (s *PostgresStore) MarkMailSent(id string, version int, sentAt time.Time) {
+	if _, err := s.db.Exec(
+		`UPDATE outbox SET sent_at = $3, last_error = NULL, claimed_until = NULL
+		WHERE id = $1 AND version = $2`,
+		id, version, sentAt,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) MarkMailFailed(id string, version int, lastError string, nextAttemptAt time.Time, dead bool) {
+	var deadAt *time.Time
+	if dead {
+		now := time.Now()
+		deadAt = &now
+	}
+	if _, err := s.db.Exec(
+		`UPDATE outbox SET last_error = $3, next_attempt_at = $4, dead_at = $5, claimed_until = NULL
+		WHERE id = $1 AND version = $2`,
+		id, version, lastError, nextAttemptAt, deadAt,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+// ReadDeadMail returns the mails that ran out of attempts, most recent first
+func (s *PostgresStore) ReadDeadMail(limit int) []models.Mail {
+	return s.readMail(
+		`SELECT `+mailColumns+` FROM dead_letters ORDER BY dead_at DESC LIMIT $1`,
+		limit,
+	)
+}
+
+// RetryMail queues a dead mail again with a fresh set of attempts, it fails
+// if a pending mail already uses the same dedup key
+func (s *PostgresStore) RetryMail(id string, now time.Time) bool {
+	result, err := s.db.Exec(
+		`UPDATE outbox SET dead_at = NULL, attempts = 0, next_attempt_at = $2, claimed_until = NULL
+		WHERE id = $1 AND dead_at IS NOT NULL`,
+		id, now,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows > 0
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+func newMail(dedupKey string, nextAttemptAt time.Time) *models.Mail {
+	return &models.Mail{
+		Id:            uuid.NewString(),
+		Recipient:     "alice@example.com",
+		Subject:       "Subject",
+		HTML:          "<p>" + dedupKey + "</p>",
+		Text:          dedupKey,
+		DedupKey:      &dedupKey,
+		CreatedAt:     nextAttemptAt,
+		NextAttemptAt: nextAttemptAt,
+	}
+}
+
+// claim claims the due mails and returns the one with the id
+func claim(store Store, id string, now time.Time) *models.Mail {
+	for _, mail := range store.ClaimMail(now, time.Minute, 100) {
+		if mail.Id == id {
+			return &mail
+		}
+	}
+	return nil
+}
+
+func TestEnqueueMailReplacesPending(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now().Round(time.Microsecond)
+		dedupKey := "verify:" + uuid.NewString()
+		mail := newMail(dedupKey, now)
+		if !store.EnqueueMail(mail) {
+			t.Fatal("unable to enqueue mail")
+		}
+		claimed := claim(store, mail.Id, now)
+		if claimed == nil {
+			t.Fatal("mail wasn't claimed")
+		}
+		store.MarkMailFailed(mail.Id, claimed.Version, "unavailable", now.Add(time.Hour), false)
+
+		// The mail is replaced and due right away with fresh attempts
+		replacement := newMail(dedupKey, now)
+		replacement.Subject = "Replaced"
+		if !store.EnqueueMail(replacement) {
+			t.Fatal("unable to enqueue replacement")
+		}
+		claimed = claim(store, mail.Id, now)
+		if claimed == nil {
+			t.Fatal("replaced mail wasn't due")
+		}
+		if claimed.Subject != "Replaced" || claimed.Attempts != 1 || claimed.LastError != nil {
+			t.Errorf("mail = %q with %d attempts and error %v, want %q with 1 attempt", claimed.Subject, claimed.Attempts, claimed.LastError, "Replaced")
+		}
+		if claim(store, replacement.Id, now.Add(time.Hour)) != nil {
+			t.Error("replacement was queued as another mail")
+		}
+	})
+}
+
+func TestDeadMail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now().Round(time.Microsecond)
+		dedupKey := "verify:" + uuid.NewString()
+		mail := newMail(dedupKey, now)
+		if !store.EnqueueMail(mail) {
+			t.Fatal("unable to enqueue mail")
+		}
+		claimed := claim(store, mail.Id, now)
+		if claimed == nil {
+			t.Fatal("mail wasn't claimed")
+		}
+		store.MarkMailFailed(mail.Id, claimed.Version, "rejected", now, true)
+		if claim(store, mail.Id, now.Add(time.Hour)) != nil {
+			t.Error("dead mail was claimed")
+		}
+		var dead bool
+		for _, letter := range store.ReadDeadMail(100) {
+			dead = dead || letter.Id == mail.Id
+		}
+		if !dead {
+			t.Fatal("dead mail wasn't listed")
+		}
+
+		// A new mail with the same key is queued on its own
+		next := newMail(dedupKey, now)
+		if !store.EnqueueMail(next) || claim(store, next.Id, now) == nil {
+			t.Fatal("mail with the key of a dead one wasn't queued")
+		}
+		if store.RetryMail(mail.Id, now) {
+			t.Error("dead mail was retried next to a pending one")
+		}
+		store.MarkMailSent(next.Id, 0, now)
+		if !store.RetryMail(mail.Id, now) {
+			t.Fatal("dead mail wasn't retried")
+		}
+		if retried := claim(store, mail.Id, now); retried == nil || retried.Attempts != 1 {
+			t.Error("retried mail didn't start over")
+		}
+	})
+}
+
+func TestEnqueueMailKeepsClaimed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now().Round(time.Microsecond)
+		dedupKey := "reset:" + uuid.NewString()
+		mail := newMail(dedupKey, now)
+		if !store.EnqueueMail(mail) {
+			t.Fatal("unable to enqueue mail")
+		}
+		claimed := claim(store, mail.Id, now)
+		if claimed == nil {
+			t.Fatal("mail wasn't claimed")
+		}
+
+		// A worker is sending the mail, so it keeps its content and lease
+		replacement := newMail(dedupKey, now.Add(time.Second))
+		replacement.Subject = "Replaced"
+		if !store.EnqueueMail(replacement) {
+			t.Fatal("unable to enqueue replacement")
+		}
+		if claim(store, mail.Id, now.Add(time.Second)) != nil {
+			t.Error("claimed mail was claimed again")
+		}
+		store.MarkMailSent(mail.Id, claimed.Version, now.Add(time.Second))
+		if claim(store, mail.Id, now.Add(time.Hour)) != nil {
+			t.Error("sent mail was claimed")
+		}
+
+		// Once the lease ran out the content is replaced, and the worker
+		// holding the old version can't mark the new one sent
+		mail = newMail(dedupKey, now)
+		if !store.EnqueueMail(mail) {
+			t.Fatal("unable to enqueue mail")
+		}
+		stale := claim(store, mail.Id, now)
+		if stale == nil {
+			t.Fatal("mail wasn't claimed")
+		}
+		replacement = newMail(dedupKey, now.Add(2*time.Minute))
+		replacement.Subject = "Replaced"
+		if !store.EnqueueMail(replacement) {
+			t.Fatal("unable to enqueue replacement")
+		}
+		store.MarkMailSent(mail.Id, stale.Version, now.Add(2*time.Minute))
+		claimed = claim(store, mail.Id, now.Add(2*time.Minute))
+		if claimed == nil {
+			t.Fatal("replaced mail was marked sent by the stale worker")
+		}
+		if claimed.Subject != "Replaced" || claimed.Version == stale.Version {
+			t.Errorf("mail = %q version %d, want %q after version %d", claimed.Subject, claimed.Version, "Replaced", stale.Version)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Credentials are stored as JSON, only the library reads their fields
+func (s *PostgresStore) CreatePasskey(passkey *models.Passkey) bool {
+	credential, err := json.Marshal(passkey.Credential)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO passkeys(id, user_id, name, credential, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		passkey.Id,
+		passkey.UserId,
+		passkey.Name,
+		credential,
+		passkey.CreatedAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func scanPasskey(row interface{ Scan(...any) error }) (*models.Passkey, error) {
+	var passkey models.Passkey
+	var credential []byte
+	if err := row.Scan(
+		&passkey.Id,
+		&passkey.UserId,
+		&passkey.Name,
+		&credential,
+		&passkey.CreatedAt,
+		&passkey.LastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(credential, &passkey.Credential); err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+func (s *PostgresStore) ReadPasskey(id string) *models.Passkey {
+	passkey, err := scanPasskey(s.db.QueryRow(
+		`SELECT id, user_id, name, credential, created_at, last_used_at
+		FROM passkeys WHERE id = $1`,
+		id,
+	))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return nil
+	}
+	return passkey
+}
+
+func (s *PostgresStore) ReadPasskeys(userId string) []models.Passkey {
+	var passkeys []models.Passkey
+	rows, err := s.db.Query(
+		`SELECT id, user_id, name, credential, created_at, last_used_at
+		FROM passkeys WHERE user_id = $1 ORDER BY created_at DESC`,
+		userId,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		passkeys = append(passkeys, *passkey)
+	}
+	return passkeys
+}
+
+func (s *PostgresStore) TouchPasskey(id string, credential webauthn.Credential, usedAt time.Time) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if _, err := s.db.Exec(
+		`UPDATE passkeys SET credential = $1, last_used_at = $2 WHERE id = $3`, data, usedAt, id,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) DeletePasskey(userId string, id string) bool {
+	result, err := s.db.Exec(
+		`DELETE FROM passkeys WHERE user_id = $1 AND id = $2`, userId, id,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
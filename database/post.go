@@ -1,13 +1,27 @@
 package database
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/lib/pq"
 )
 
-func CreatePost(userId string, post *models.Post) bool {
-	if _, err := db.Exec(
+const postColumns = `user_id, id, body, edited_at, created_at`
+
+// CreatePost stores the post along with its media
+func (s *PostgresStore) CreatePost(userId string, post *models.Post) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(
 		`INSERT INTO posts(user_id, id, body, created_at)
 		VALUES ($1, $2, $3, $4)`,
 		userId, post.Id, post.Body, post.CreatedAt,
@@ -15,35 +29,139 @@ func CreatePost(userId string, post *models.Post) bool {
 		log.Println(err)
 		return false
 	}
+	for _, media := range post.Media {
+		if _, err := tx.Exec(
+			`INSERT INTO post_media(post_id, position, key, url, content_type, alt)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			post.Id, media.Position, media.Key, media.URL, media.ContentType, media.Alt,
+		); err != nil {
+			log.Println(err)
+			return false
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return false
+	}
 	return true
 }
 
-func ReadPost(id string) *models.Post {
+// readMedia returns the media of the given posts in their order
+func (s *PostgresStore) readMedia(condition string, arg any) []models.Media {
+	var media []models.Media
+	rows, err := s.db.Query(
+		`SELECT post_id, position, key, url, content_type, alt FROM post_media
+		WHERE `+condition+` ORDER BY post_id, position`,
+		arg,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.Media
+		rows.Scan(&item.PostId, &item.Position, &item.Key, &item.URL, &item.ContentType, &item.Alt)
+		media = append(media, item)
+	}
+	return media
+}
+
+// withMedia attaches their media to the posts
+func (s *PostgresStore) withMedia(posts []models.Post) []models.Post {
+	if len(posts) == 0 {
+		return posts
+	}
+	ids := make([]string, len(posts))
+	for index, post := range posts {
+		ids[index] = post.Id
+	}
+	byPost := make(map[string][]models.Media)
+	for _, item := range s.readMedia(`post_id = ANY($1)`, pq.Array(ids)) {
+		byPost[item.PostId] = append(byPost[item.PostId], item)
+	}
+	for index := range posts {
+		posts[index].Media = byPost[posts[index].Id]
+	}
+	return posts
+}
+
+// ReadUserMedia returns the media of every post of the user
+func (s *PostgresStore) ReadUserMedia(userId string) []models.Media {
+	return s.readMedia(`post_id IN (SELECT id FROM posts WHERE user_id = $1)`, userId)
+}
+
+// MediaInUse tells if a post still attaches the blob under the key
+func (s *PostgresStore) MediaInUse(key string) bool {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM post_media WHERE key = $1)`, key).Scan(&exists); err != nil {
+		log.Println(err)
+		// Keep the blob if unsure
+		return true
+	}
+	return exists
+}
+
+// LockMedia takes an advisory lock per key, in order so that requests
+// locking several keys can't deadlock. The locks belong to the connection,
+// which is held until unlock.
+func (s *PostgresStore) LockMedia(keys []string) (func(), bool) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	unlock := func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock_all()`); err != nil {
+			log.Println(err)
+		}
+		conn.Close()
+	}
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtextextended($1, 0))`, key); err != nil {
+			log.Println(err)
+			unlock()
+			return nil, false
+		}
+	}
+	return unlock, true
+}
+
+func (s *PostgresStore) ReadPost(id string) *models.Post {
 	var post models.Post
-	if err := db.QueryRow(`SELECT * FROM posts WHERE id = $1`, id).Scan(
-		&post.UserId, &post.Id, &post.Body, &post.CreatedAt,
+	if err := s.db.QueryRow(`SELECT `+postColumns+` FROM posts WHERE id = $1`, id).Scan(
+		&post.UserId, &post.Id, &post.Body, &post.EditedAt, &post.CreatedAt,
 	); err != nil {
 		log.Println(err)
 		return nil
 	}
+	post.Media = s.readMedia(`post_id = $1`, post.Id)
 	return &post
 }
 
-func ReadPostsCount(userId string) int {
+func (s *PostgresStore) ReadPostsCount(userId string) int {
 	var count int
-	if err := db.QueryRow(`SELECT COUNT(*) FROM posts WHERE user_id = $1`, userId).Scan(&count); err != nil {
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM posts WHERE user_id = $1`, userId).Scan(&count); err != nil {
 		log.Println(err)
 		return 0
 	}
 	return count
 }
 
-func ReadPosts(userId string, limit int, offset int) []models.Post {
+func (s *PostgresStore) ReadPosts(userId string, limit int, cursor *models.Cursor) []models.Post {
 	var posts []models.Post
-	rows, err := db.Query(
-		`SELECT * FROM posts WHERE user_id = $1 ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3`,
-		userId, limit, offset,
+	condition, args := keyset(cursor, 3)
+	rows, err := s.db.Query(
+		fmt.Sprintf(
+			`SELECT %s FROM posts WHERE user_id = $1 %s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2`,
+			postColumns, condition,
+		),
+		append([]any{userId, limit}, args...)...,
 	)
 	if err != nil {
 		log.Println(err)
@@ -53,20 +171,24 @@ func ReadPosts(userId string, limit int, offset int) []models.Post {
 	defer rows.Close()
 	for rows.Next() {
 		var post models.Post
-		rows.Scan(&post.UserId, &post.Id, &post.Body, &post.CreatedAt)
+		rows.Scan(&post.UserId, &post.Id, &post.Body, &post.EditedAt, &post.CreatedAt)
 		posts = append(posts, post)
 	}
-	return posts
+	return s.withMedia(posts)
 }
 
-func ReadFeedPosts(userId string, limit int, offset int) []models.Post {
+func (s *PostgresStore) ReadFeedPosts(userId string, limit int, cursor *models.Cursor) []models.Post {
 	var posts []models.Post
-	rows, err := db.Query(
-		`SELECT * FROM posts WHERE user_id IN
-		(SELECT follow_id FROM follows WHERE user_id = $1)
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3`,
-		userId, limit, offset,
+	condition, args := keyset(cursor, 3)
+	rows, err := s.db.Query(
+		fmt.Sprintf(
+			`SELECT %s FROM posts WHERE user_id IN
+			(SELECT follow_id FROM follows WHERE user_id = $1) %s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2`,
+			postColumns, condition,
+		),
+		append([]any{userId, limit}, args...)...,
 	)
 	if err != nil {
 		log.Println(err)
@@ -75,23 +197,84 @@ func ReadFeedPosts(userId string, limit int, offset int) []models.Post {
 	defer rows.Close()
 	for rows.Next() {
 		var post models.Post
-		rows.Scan(&post.UserId, &post.Id, &post.Body, &post.CreatedAt)
+		rows.Scan(&post.UserId, &post.Id, &post.Body, &post.EditedAt, &post.CreatedAt)
 		posts = append(posts, post)
 	}
-	return posts
+	return s.withMedia(posts)
+}
+
+// UpdatePost replaces the body of the post, keeping the previous one as the
+// revision with the given id
+func (s *PostgresStore) UpdatePost(id string, body string, revisionId string, editedAt time.Time) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer tx.Rollback()
+	var revision models.Revision
+	// Lock the post so concurrent edits each keep the body they replace
+	if err := tx.QueryRow(
+		`SELECT body, COALESCE(edited_at, created_at) FROM posts WHERE id = $1 FOR UPDATE`,
+		id,
+	).Scan(&revision.Body, &revision.CreatedAt); err != nil {
+		log.Println(err)
+		return false
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO post_revisions(post_id, id, body, created_at, replaced_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		id, revisionId, revision.Body, revision.CreatedAt, editedAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	if _, err := tx.Exec(
+		`UPDATE posts SET body = $2, edited_at = $3 WHERE id = $1`,
+		id, body, editedAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// ReadRevisions returns the previous bodies of the post, newest first
+func (s *PostgresStore) ReadRevisions(postId string) []models.Revision {
+	var revisions []models.Revision
+	rows, err := s.db.Query(
+		`SELECT post_id, id, body, created_at, replaced_at FROM post_revisions
+		WHERE post_id = $1 ORDER BY replaced_at DESC`,
+		postId,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var revision models.Revision
+		rows.Scan(&revision.PostId, &revision.Id, &revision.Body, &revision.CreatedAt, &revision.ReplacedAt)
+		revisions = append(revisions, revision)
+	}
+	return revisions
 }
 
-func DeletePost(id string) bool {
-	if _, err := db.Exec(`DELETE FROM posts WHERE id = $1`, id); err != nil {
+func (s *PostgresStore) DeletePost(id string) bool {
+	if _, err := s.db.Exec(`DELETE FROM posts WHERE id = $1`, id); err != nil {
 		log.Println(err)
 		return false
 	}
 	return true
 }
 
-func Voted(userId string, id string) bool {
+func (s *PostgresStore) Voted(userId string, id string) bool {
 	var count int
-	db.QueryRow(
+	s.db.QueryRow(
 		`SELECT COUNT(*) FROM votes WHERE user_id = $1 AND id = $2`,
 		userId, id,
 	).Scan(&count)
@@ -104,9 +287,9 @@ func Voted(userId string, id string) bool {
 	}
 }
 
-func ToggleVote(userId string, id string) {
+func (s *PostgresStore) ToggleVote(userId string, id string) {
 	var query string
-	voted := Voted(userId, id)
+	voted := s.Voted(userId, id)
 
 	switch voted {
 	case false:
@@ -114,14 +297,14 @@ func ToggleVote(userId string, id string) {
 	default:
 		query = `DELETE FROM votes WHERE user_id = $1 AND id = $2`
 	}
-	if _, err := db.Exec(query, userId, id); err != nil {
+	if _, err := s.db.Exec(query, userId, id); err != nil {
 		log.Println(err)
 	}
 }
 
-func ReadVotes(id string) []string {
+func (s *PostgresStore) ReadVotes(id string) []string {
 	var voters []string
-	rows, err := db.Query(
+	rows, err := s.db.Query(
 		`SELECT username FROM t_users WHERE id IN
 		(SELECT user_id FROM votes WHERE id = $1)`,
 		id,
@@ -139,8 +322,8 @@ func ReadVotes(id string) []string {
 	return voters
 }
 
-func CreateComment(userId string, postId string, comment *models.Comment) bool {
-	if _, err := db.Exec(
+func (s *PostgresStore) CreateComment(userId string, postId string, comment *models.Comment) bool {
+	if _, err := s.db.Exec(
 		`INSERT INTO comments (user_id, post_id, id, body, created_at)
 		VALUES ($1, $2, $3, $4, $5)`,
 		userId, postId, comment.Id, comment.Body, comment.CreatedAt,
@@ -151,9 +334,9 @@ func CreateComment(userId string, postId string, comment *models.Comment) bool {
 	return true
 }
 
-func ReadComment(id string) *models.Comment {
+func (s *PostgresStore) ReadComment(id string) *models.Comment {
 	var comment models.Comment
-	if err := db.QueryRow(`SELECT * FROM comments WHERE id = $1`, id).Scan(
+	if err := s.db.QueryRow(`SELECT * FROM comments WHERE id = $1`, id).Scan(
 		&comment.UserId,
 		&comment.PostId,
 		&comment.Id,
@@ -166,13 +349,17 @@ func ReadComment(id string) *models.Comment {
 	return &comment
 }
 
-func ReadComments(postId string, limit int, offset int) []models.Comment {
+func (s *PostgresStore) ReadComments(postId string, limit int, cursor *models.Cursor) []models.Comment {
 	var comments []models.Comment
-	rows, err := db.Query(
-		`SELECT * FROM comments WHERE post_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3`,
-		postId, limit, offset,
+	condition, args := keyset(cursor, 3)
+	rows, err := s.db.Query(
+		fmt.Sprintf(
+			`SELECT * FROM comments WHERE post_id = $1 %s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2`,
+			condition,
+		),
+		append([]any{postId, limit}, args...)...,
 	)
 	if err != nil {
 		log.Println(err)
@@ -193,8 +380,8 @@ func ReadComments(postId string, limit int, offset int) []models.Comment {
 	return comments
 }
 
-func DeleteComment(id string) bool {
-	if _, err := db.Exec(`DELETE FROM comments WHERE id = $1`, id); err != nil {
+func (s *PostgresStore) DeleteComment(id string) bool {
+	if _, err := s.db.Exec(`DELETE FROM comments WHERE id = $1`, id); err != nil {
 		log.Println(err)
 		return false
 	}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+func TestDeletePostCascades(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		now := time.Now().Round(time.Microsecond)
+		key := "posts/" + uuid.NewString() + ".png"
+		post := &models.Post{
+			Id:        uuid.NewString(),
+			Body:      "post",
+			Media:     []models.Media{{Key: key, URL: "/media/" + key, ContentType: "image/png", Alt: "alt"}},
+			CreatedAt: now,
+		}
+		kept := &models.Post{Id: uuid.NewString(), Body: "kept", CreatedAt: now}
+		if !store.CreatePost(user.Id, post) || !store.CreatePost(user.Id, kept) {
+			t.Fatal("unable to create posts")
+		}
+		comment := &models.Comment{Id: uuid.NewString(), Body: "comment", CreatedAt: now}
+		keptComment := &models.Comment{Id: uuid.NewString(), Body: "kept", CreatedAt: now}
+		if !store.CreateComment(other.Id, post.Id, comment) || !store.CreateComment(other.Id, kept.Id, keptComment) {
+			t.Fatal("unable to create comments")
+		}
+		store.ToggleVote(other.Id, post.Id)
+		store.ToggleVote(other.Id, kept.Id)
+		if !store.UpdatePost(post.Id, "edited", uuid.NewString(), now.Add(time.Minute)) {
+			t.Fatal("unable to edit post")
+		}
+		if len(store.ReadRevisions(post.Id)) != 1 || !store.MediaInUse(key) {
+			t.Fatal("revision or media weren't saved")
+		}
+
+		if !store.DeletePost(post.Id) {
+			t.Fatal("unable to delete post")
+		}
+		if store.ReadPost(post.Id) != nil {
+			t.Error("post wasn't deleted")
+		}
+		if store.ReadComment(comment.Id) != nil {
+			t.Error("comment on the post wasn't deleted")
+		}
+		if len(store.ReadVotes(post.Id)) != 0 {
+			t.Error("votes on the post weren't deleted")
+		}
+		if len(store.ReadRevisions(post.Id)) != 0 {
+			t.Error("revisions of the post weren't deleted")
+		}
+		if store.MediaInUse(key) || len(store.ReadUserMedia(user.Id)) != 0 {
+			t.Error("media of the post wasn't deleted")
+		}
+		if store.ReadPost(kept.Id) == nil || store.ReadComment(keptComment.Id) == nil || len(store.ReadVotes(kept.Id)) != 1 {
+			t.Error("other post was affected")
+		}
+	})
+}
+
+func TestLockMedia(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		keys := []string{"posts/" + uuid.NewString() + ".png"}
+		unlock, ok := store.LockMedia(keys)
+		if !ok {
+			t.Fatal("unable to lock media")
+		}
+		locked := make(chan func())
+		go func() {
+			unlock, _ := store.LockMedia(keys)
+			locked <- unlock
+		}()
+		select {
+		case <-locked:
+			t.Fatal("key locked twice")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		select {
+		case unlock := <-locked:
+			unlock()
+		case <-time.After(5 * time.Second):
+			t.Fatal("key not released")
+		}
+	})
+}
+
+// Posts sharing created_at are ordered by id, so pages neither skip nor
+// repeat them
+func TestReadPostsKeysetTies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		now := time.Now().Round(time.Microsecond)
+		var want []string
+		for i := 0; i < 4; i++ {
+			post := &models.Post{Id: uuid.NewString(), Body: "older", CreatedAt: now.Add(-time.Minute)}
+			if !store.CreatePost(user.Id, post) {
+				t.Fatal("unable to create post")
+			}
+		}
+		for i := 0; i < 7; i++ {
+			post := &models.Post{Id: uuid.NewString(), Body: "tied", CreatedAt: now}
+			if !store.CreatePost(user.Id, post) {
+				t.Fatal("unable to create post")
+			}
+		}
+		all := store.ReadPosts(user.Id, 100, nil)
+		for _, post := range all {
+			want = append(want, post.Id)
+		}
+		if len(want) != 11 {
+			t.Fatalf("read %d posts, want 11", len(want))
+		}
+		for i := 1; i < len(all); i++ {
+			if position := all[i-1].Cursor(); !position.Precedes(all[i].Cursor()) {
+				t.Fatalf("posts %d and %d out of order", i-1, i)
+			}
+		}
+
+		for _, limit := range []int{1, 2, 3, 5} {
+			var got []string
+			var cursor *models.Cursor
+			for {
+				page := store.ReadPosts(user.Id, limit, cursor)
+				for _, post := range page {
+					got = append(got, post.Id)
+				}
+				if len(page) < limit {
+					break
+				}
+				next := page[len(page)-1].Cursor()
+				cursor = &next
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("pages of %d: got %v, want %v", limit, got, want)
+			}
+		}
+	})
+}
@@ -2,28 +2,30 @@ package database
 
 import (
 	"database/sql"
-	"io/ioutil"
-	"os"
+	"fmt"
 
-	"github.com/joho/godotenv"
+	"github.com/Devansh3712/tsuki-go/models"
 	_ "github.com/lib/pq"
 )
 
-var db *sql.DB
+type PostgresStore struct {
+	db *sql.DB
+}
 
-func init() {
-	godotenv.Load(".env")
-	var err error
-	db, err = sql.Open("postgres", os.Getenv("POSTGRES_URI"))
-	if err != nil {
-		panic(err)
-	}
-	data, err := ioutil.ReadFile("database/init.sql")
+func NewPostgresStore(uri string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", uri)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	script := string(data)
-	if _, err := db.Exec(script); err != nil {
-		panic(err)
+	return &PostgresStore{db: db}, nil
+}
+
+// keyset returns the condition selecting rows after the cursor in
+// created_at DESC, id DESC order, with placeholders numbered from index
+func keyset(cursor *models.Cursor, index int) (string, []any) {
+	if cursor == nil {
+		return "", nil
 	}
+	return fmt.Sprintf("AND (created_at, id) < ($%d, $%d)", index, index+1),
+		[]any{cursor.CreatedAt, cursor.Id}
 }
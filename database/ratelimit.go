@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+// The bucket is refilled and a token taken in one statement, so concurrent
+// requests on different instances can't take the same token
+func (s *PostgresStore) TakeToken(key string, limit models.RateLimit, now time.Time) (bool, time.Duration) {
+	interval := limit.Interval.Seconds()
+	result, err := s.db.Exec(
+		`INSERT INTO rate_limits(key, tokens, updated_at) VALUES ($1, $2::float8 - 1, $4::timestamptz)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST($2::float8, rate_limits.tokens + GREATEST(0, EXTRACT(EPOCH FROM $4::timestamptz - rate_limits.updated_at)) / $3::float8) - 1,
+			updated_at = $4::timestamptz
+		WHERE LEAST($2::float8, rate_limits.tokens + GREATEST(0, EXTRACT(EPOCH FROM $4::timestamptz - rate_limits.updated_at)) / $3::float8) >= 1`,
+		key, limit.Burst, interval, now,
+	)
+	if err != nil {
+		// Logins keep working if the limits can't be read
+		log.Println(err)
+		return true, 0
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+		return true, 0
+	}
+	var wait float64
+	if err := s.db.QueryRow(
+		`SELECT (1 - LEAST($2::float8, tokens + GREATEST(0, EXTRACT(EPOCH FROM $4::timestamptz - updated_at)) / $3::float8)) * $3::float8
+		FROM rate_limits WHERE key = $1`,
+		key, limit.Burst, interval, now,
+	).Scan(&wait); err != nil {
+		log.Println(err)
+		return false, limit.Interval
+	}
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (s *PostgresStore) PruneRateLimits(before time.Time) {
+	if _, err := s.db.Exec(`DELETE FROM rate_limits WHERE updated_at < $1`, before); err != nil {
+		log.Println(err)
+	}
+	if _, err := s.db.Exec(
+		`DELETE FROM lockouts WHERE first_failed_at < $1 AND (locked_until IS NULL OR locked_until < $1)`,
+		before,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) ReadLockout(userId string) *models.Lockout {
+	var lockout models.Lockout
+	if err := s.db.QueryRow(
+		`SELECT user_id, failures, first_failed_at, locked_until FROM lockouts WHERE user_id = $1`,
+		userId,
+	).Scan(
+		&lockout.UserId,
+		&lockout.Failures,
+		&lockout.FirstFailedAt,
+		&lockout.LockedUntil,
+	); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return nil
+	}
+	return &lockout
+}
+
+func (s *PostgresStore) RecordLoginFailure(userId string, now time.Time, window time.Duration) int {
+	var failures int
+	if err := s.db.QueryRow(
+		`INSERT INTO lockouts(user_id, failures, first_failed_at) VALUES ($1, 1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET
+			failures = CASE WHEN lockouts.first_failed_at < $3 THEN 1 ELSE lockouts.failures + 1 END,
+			first_failed_at = CASE WHEN lockouts.first_failed_at < $3 THEN $2 ELSE lockouts.first_failed_at END
+		RETURNING failures`,
+		userId, now, now.Add(-window),
+	).Scan(&failures); err != nil {
+		log.Println(err)
+		return 0
+	}
+	return failures
+}
+
+func (s *PostgresStore) LockUser(userId string, now time.Time, until time.Time) bool {
+	result, err := s.db.Exec(
+		`UPDATE lockouts SET failures = 0, first_failed_at = $2, locked_until = $3
+		WHERE user_id = $1 AND (locked_until IS NULL OR locked_until <= $2)`,
+		userId, now, until,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows > 0
+}
+
+func (s *PostgresStore) DeleteLockout(userId string) {
+	if _, err := s.db.Exec(`DELETE FROM lockouts WHERE user_id = $1`, userId); err != nil {
+		log.Println(err)
+	}
+}
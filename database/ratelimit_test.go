@@ -0,0 +1,150 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+// The login policy of middleware: 10 failures within 15 minutes lock the
+// account for 15 minutes
+const (
+	testLockoutThreshold = 10
+	testLockoutWindow    = 15 * time.Minute
+)
+
+// forEachRateLimitStore runs the test against every rate limit store, with
+// the store holding its users. PostgresStore keeps both.
+func forEachRateLimitStore(t *testing.T, test func(t *testing.T, store Store, limits RateLimitStore)) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		limits, ok := store.(RateLimitStore)
+		if !ok {
+			limits = NewMemoryRateLimitStore()
+		}
+		test(t, store, limits)
+	})
+}
+
+func assertWait(t *testing.T, wait time.Duration, want time.Duration) {
+	t.Helper()
+	if diff := wait - want; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("got wait %v, want %v", wait, want)
+	}
+}
+
+func TestTakeToken(t *testing.T) {
+	forEachRateLimitStore(t, func(t *testing.T, _ Store, limits RateLimitStore) {
+		key := "test:" + uuid.NewString()
+		limit := models.RateLimit{Burst: 3, Interval: 10 * time.Second}
+		now := time.Now().Round(time.Microsecond)
+		for i := 0; i < limit.Burst; i++ {
+			if ok, _ := limits.TakeToken(key, limit, now); !ok {
+				t.Fatalf("token %d refused", i+1)
+			}
+		}
+		ok, wait := limits.TakeToken(key, limit, now)
+		if ok {
+			t.Fatal("token taken from an empty bucket")
+		}
+		assertWait(t, wait, limit.Interval)
+
+		// Half a token refilled
+		ok, wait = limits.TakeToken(key, limit, now.Add(limit.Interval/2))
+		if ok {
+			t.Fatal("token taken before the refill")
+		}
+		assertWait(t, wait, limit.Interval/2)
+
+		if ok, _ := limits.TakeToken(key, limit, now.Add(limit.Interval)); !ok {
+			t.Fatal("token refused after the refill")
+		}
+		ok, wait = limits.TakeToken(key, limit, now.Add(limit.Interval))
+		if ok {
+			t.Fatal("refilled token taken twice")
+		}
+		assertWait(t, wait, limit.Interval)
+
+		// The bucket never holds more than Burst tokens
+		later := now.Add(time.Hour)
+		for i := 0; i < limit.Burst; i++ {
+			if ok, _ := limits.TakeToken(key, limit, later); !ok {
+				t.Fatalf("token %d refused after an hour", i+1)
+			}
+		}
+		if ok, _ := limits.TakeToken(key, limit, later); ok {
+			t.Error("bucket refilled past its burst")
+		}
+	})
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	forEachRateLimitStore(t, func(t *testing.T, store Store, limits RateLimitStore) {
+		user := newUser(t, store)
+		now := time.Now().Round(time.Microsecond)
+		var failures int
+		for i := 0; i < testLockoutThreshold; i++ {
+			failures = limits.RecordLoginFailure(user.Id, now.Add(time.Duration(i)*time.Minute), testLockoutWindow)
+			if failures != i+1 {
+				t.Fatalf("got %d failures, want %d", failures, i+1)
+			}
+		}
+		if failures < testLockoutThreshold {
+			t.Fatalf("%d failures within %v don't reach the lockout", failures, testLockoutWindow)
+		}
+		lockout := limits.ReadLockout(user.Id)
+		if lockout == nil || !lockout.FirstFailedAt.Equal(now) {
+			t.Fatalf("got lockout %+v, want first failure at %v", lockout, now)
+		}
+
+		// A failure after the window starts counting again
+		later := now.Add(testLockoutWindow + time.Second)
+		if failures := limits.RecordLoginFailure(user.Id, later, testLockoutWindow); failures != 1 {
+			t.Errorf("got %d failures after the window, want 1", failures)
+		}
+		if lockout := limits.ReadLockout(user.Id); lockout == nil || !lockout.FirstFailedAt.Equal(later) {
+			t.Errorf("got lockout %+v, want first failure at %v", lockout, later)
+		}
+	})
+}
+
+func TestLockUser(t *testing.T) {
+	forEachRateLimitStore(t, func(t *testing.T, store Store, limits RateLimitStore) {
+		user := newUser(t, store)
+		now := time.Now().Round(time.Microsecond)
+		until := now.Add(testLockoutWindow)
+		for i := 0; i < testLockoutThreshold; i++ {
+			limits.RecordLoginFailure(user.Id, now, testLockoutWindow)
+		}
+		if !limits.LockUser(user.Id, now, until) {
+			t.Fatal("unable to lock user")
+		}
+		lockout := limits.ReadLockout(user.Id)
+		if lockout == nil || lockout.Failures != 0 || !lockout.Locked(now) {
+			t.Fatalf("got lockout %+v, want locked without failures", lockout)
+		}
+		if !lockout.Locked(until.Add(-time.Second)) {
+			t.Error("lockout expired early")
+		}
+		if limits.LockUser(user.Id, now.Add(time.Minute), now.Add(time.Hour)) {
+			t.Error("locked user locked again")
+		}
+		if lockout := limits.ReadLockout(user.Id); lockout == nil || !lockout.LockedUntil.Equal(until) {
+			t.Errorf("got lockout %+v, want locked until %v", lockout, until)
+		}
+
+		// The lockout expires
+		if lockout.Locked(until) {
+			t.Error("lockout didn't expire")
+		}
+		if !limits.LockUser(user.Id, until, until.Add(testLockoutWindow)) {
+			t.Error("unable to lock user after the lockout expired")
+		}
+
+		limits.DeleteLockout(user.Id)
+		if lockout := limits.ReadLockout(user.Id); lockout != nil {
+			t.Errorf("got lockout %+v after deleting it", lockout)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+func (s *PostgresStore) CreateSession(session *models.Session) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO sessions(id, user_id, refresh_hash, user_agent, ip, created_at, last_seen_at, rotated_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		session.Id,
+		session.UserId,
+		session.RefreshHash,
+		session.UserAgent,
+		session.IP,
+		session.CreatedAt,
+		session.LastSeenAt,
+		session.RotatedAt,
+		session.ExpiresAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) ReadSession(id string) *models.Session {
+	var session models.Session
+	if err := s.db.QueryRow(
+		`SELECT id, user_id, refresh_hash, previous_hash, user_agent, ip, created_at,
+		last_seen_at, rotated_at, expires_at, revoked_at
+		FROM sessions WHERE id = $1`,
+		id,
+	).Scan(
+		&session.Id,
+		&session.UserId,
+		&session.RefreshHash,
+		&session.PreviousHash,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.RotatedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return &session
+}
+
+// ReadSessions returns the active sessions of a user, most recently used
+// first
+func (s *PostgresStore) ReadSessions(userId string) []models.Session {
+	var sessions []models.Session
+	rows, err := s.db.Query(
+		`SELECT id, user_id, refresh_hash, previous_hash, user_agent, ip, created_at,
+		last_seen_at, rotated_at, expires_at, revoked_at
+		FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_seen_at DESC`,
+		userId, time.Now(),
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session models.Session
+		rows.Scan(
+			&session.Id,
+			&session.UserId,
+			&session.RefreshHash,
+			&session.PreviousHash,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.RotatedAt,
+			&session.ExpiresAt,
+			&session.RevokedAt,
+		)
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (s *PostgresStore) RotateSession(id string, hash string, newHash string, ip string, rotatedAt time.Time, expiresAt time.Time) bool {
+	result, err := s.db.Exec(
+		`UPDATE sessions SET refresh_hash = $3, previous_hash = $2, ip = $4,
+		last_seen_at = $5, rotated_at = $5, expires_at = $6
+		WHERE id = $1 AND refresh_hash = $2 AND revoked_at IS NULL`,
+		id, hash, newHash, ip, rotatedAt, expiresAt,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+func (s *PostgresStore) UsePreviousHash(id string, hash string) bool {
+	result, err := s.db.Exec(
+		`UPDATE sessions SET previous_hash = NULL
+		WHERE id = $1 AND previous_hash = $2 AND revoked_at IS NULL`,
+		id, hash,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+func (s *PostgresStore) TouchSession(id string, seenAt time.Time) {
+	if _, err := s.db.Exec(
+		`UPDATE sessions SET last_seen_at = $1 WHERE id = $2`, seenAt, id,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) RevokeSession(userId string, id string) bool {
+	result, err := s.db.Exec(
+		`UPDATE sessions SET revoked_at = $3
+		WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL`,
+		userId, id, time.Now(),
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+// RevokeSessions revokes every session of the user except the given one,
+// pass an empty id to revoke all of them
+func (s *PostgresStore) RevokeSessions(userId string, exceptId string) bool {
+	if _, err := s.db.Exec(
+		`UPDATE sessions SET revoked_at = $3
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userId, exceptId, time.Now(),
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Keys under which the stores are saved in the gin context
+const (
+	StoreKey          = "store"
+	RateLimitStoreKey = "rateLimitStore"
+)
+
+type UserStore interface {
+	CreateUser(user *models.User) bool
+	ReadUserByName(username string) *models.User
+	ReadUserByEmail(email string) *models.User
+	ReadUserById(id string) *models.User
+	ReadUsers(username string, limit int, cursor *models.Cursor) []models.User
+	UpdateUser(id string, updates map[string]any) bool
+	DeleteUser(id string) bool
+	// AvatarInUse tells if a user shows the URL as one of their avatar sizes
+	AvatarInUse(url string) bool
+}
+
+type FollowStore interface {
+	Followed(userId string, followId string) bool
+	ToggleFollow(userId string, followId string)
+	ReadFollowers(userId string) []string
+	ReadFollowersCount(userId string) int
+	ReadFollowing(userId string) []string
+	ReadFollowingCount(userId string) int
+}
+
+// VerificationStore keeps the tokens of mailed links under the id in the
+// link, along with the user and purpose of the token
+type VerificationStore interface {
+	CreateVerificationId(token string, id string, userId string, purpose string) bool
+	ReadVerificationId(id string) string
+	DeleteVerificationId(id string) bool
+	DeleteVerificationIds(userId string, purposes []string) bool
+}
+
+type PostStore interface {
+	CreatePost(userId string, post *models.Post) bool
+	ReadPost(id string) *models.Post
+	ReadPostsCount(userId string) int
+	ReadPosts(userId string, limit int, cursor *models.Cursor) []models.Post
+	ReadFeedPosts(userId string, limit int, cursor *models.Cursor) []models.Post
+	DeletePost(id string) bool
+	UpdatePost(id string, body string, revisionId string, editedAt time.Time) bool
+	ReadRevisions(postId string) []models.Revision
+	ReadUserMedia(userId string) []models.Media
+	MediaInUse(key string) bool
+	// LockMedia blocks until no other request holds any of the blob keys,
+	// it reports false if they can't be locked
+	LockMedia(keys []string) (unlock func(), ok bool)
+}
+
+type VoteStore interface {
+	Voted(userId string, id string) bool
+	ToggleVote(userId string, id string)
+	ReadVotes(id string) []string
+}
+
+type CommentStore interface {
+	CreateComment(userId string, postId string, comment *models.Comment) bool
+	ReadComment(id string) *models.Comment
+	ReadComments(postId string, limit int, cursor *models.Cursor) []models.Comment
+	DeleteComment(id string) bool
+}
+
+type AccessTokenStore interface {
+	CreateAccessToken(token *models.AccessToken) bool
+	ReadAccessTokenByHash(hash string) *models.AccessToken
+	ReadAccessTokens(userId string) []models.AccessToken
+	TouchAccessToken(id string, usedAt time.Time)
+	DeleteAccessToken(userId string, id string) bool
+	DeleteAccessTokens(userId string) bool
+}
+
+// SessionStore records logins, RotateSession only succeeds if the refresh
+// token hash is still the current one so concurrent refreshes can't both win.
+// UsePreviousHash forgets the replaced hash, so it is only accepted once.
+type SessionStore interface {
+	CreateSession(session *models.Session) bool
+	ReadSession(id string) *models.Session
+	ReadSessions(userId string) []models.Session
+	RotateSession(id string, hash string, newHash string, ip string, rotatedAt time.Time, expiresAt time.Time) bool
+	UsePreviousHash(id string, hash string) bool
+	TouchSession(id string, seenAt time.Time)
+	RevokeSession(userId string, id string) bool
+	RevokeSessions(userId string, exceptId string) bool
+}
+
+// TwoFactorStore keeps TOTP secrets and the hashes of recovery codes.
+// UseTOTPStep and UseRecoveryCode only succeed once for a given value.
+type TwoFactorStore interface {
+	CreateTwoFactor(twoFactor *models.TwoFactor) bool
+	ReadTwoFactor(userId string) *models.TwoFactor
+	EnableTwoFactor(userId string, step int64, codeHashes []string) bool
+	UseTOTPStep(userId string, step int64) bool
+	UseRecoveryCode(userId string, codeHash string) bool
+	ReadRecoveryCodesCount(userId string) int
+	ReplaceRecoveryCodes(userId string, codeHashes []string) bool
+	DeleteTwoFactor(userId string) bool
+}
+
+// PasskeyStore keeps WebAuthn credentials, TouchPasskey saves the sign
+// count and flags after a login
+type PasskeyStore interface {
+	CreatePasskey(passkey *models.Passkey) bool
+	ReadPasskey(id string) *models.Passkey
+	ReadPasskeys(userId string) []models.Passkey
+	TouchPasskey(id string, credential webauthn.Credential, usedAt time.Time)
+	DeletePasskey(userId string, id string) bool
+}
+
+// IdentityStore keeps the provider accounts linked to users
+type IdentityStore interface {
+	CreateIdentity(identity *models.Identity) bool
+	ReadIdentity(provider string, subject string) *models.Identity
+	ReadIdentities(userId string) []models.Identity
+	TouchIdentity(provider string, subject string, usedAt time.Time)
+	DeleteIdentity(userId string, provider string) bool
+}
+
+// OutboxStore queues outgoing mail. ClaimMail counts an attempt and hides
+// the claimed mails for the lease, so several workers never send the same
+// mail at once.
+type OutboxStore interface {
+	EnqueueMail(mail *models.Mail) bool
+	ClaimMail(now time.Time, lease time.Duration, limit int) []models.Mail
+	MarkMailSent(id string, version int, sentAt time.Time)
+	MarkMailFailed(id string, version int, lastError string, nextAttemptAt time.Time, dead bool)
+	ReadDeadMail(limit int) []models.Mail
+	RetryMail(id string, now time.Time) bool
+}
+
+// RateLimitStore keeps the token buckets of throttled requests and the
+// failed logins of users. PostgresStore shares them between instances,
+// MemoryRateLimitStore keeps them per instance.
+type RateLimitStore interface {
+	// TakeToken reports whether the bucket of the key had a token left,
+	// and otherwise how long until it has one
+	TakeToken(key string, limit models.RateLimit, now time.Time) (bool, time.Duration)
+	PruneRateLimits(before time.Time)
+	ReadLockout(userId string) *models.Lockout
+	// RecordLoginFailure returns the failures within the window, counting
+	// from scratch once the first one is older than the window
+	RecordLoginFailure(userId string, now time.Time, window time.Duration) int
+	// LockUser only succeeds if the user isn't locked already
+	LockUser(userId string, now time.Time, until time.Time) bool
+	DeleteLockout(userId string)
+}
+
+// Store is the persistence layer used by the route handlers. PostgresStore
+// is used in production, MemoryStore for tests and local development.
+type Store interface {
+	UserStore
+	FollowStore
+	VerificationStore
+	PostStore
+	VoteStore
+	CommentStore
+	AccessTokenStore
+	SessionStore
+	TwoFactorStore
+	PasskeyStore
+	OutboxStore
+	IdentityStore
+}
+
+// Default returns the store injected by middleware.StoreMiddleware
+func Default(c *gin.Context) Store {
+	return c.MustGet(StoreKey).(Store)
+}
+
+// RateLimits returns the store injected by middleware.RateLimitStoreMiddleware
+func RateLimits(c *gin.Context) RateLimitStore {
+	return c.MustGet(RateLimitStoreKey).(RateLimitStore)
+}
+
+var (
+	_ Store          = (*PostgresStore)(nil)
+	_ Store          = (*MemoryStore)(nil)
+	_ RateLimitStore = (*PostgresStore)(nil)
+	_ RateLimitStore = (*MemoryRateLimitStore)(nil)
+)
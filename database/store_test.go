@@ -0,0 +1,61 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+// testStores returns the stores a test runs against: MemoryStore and, when
+// TEST_POSTGRES_URI names a scratch database, PostgresStore with the
+// migrations applied. Tests create their own users, so they can share the
+// database.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	stores := map[string]Store{"memory": NewMemoryStore()}
+	uri := os.Getenv("TEST_POSTGRES_URI")
+	if uri == "" {
+		return stores
+	}
+	store, err := NewPostgresStore(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+	stores["postgres"] = store
+	return stores
+}
+
+// forEachStore runs the test against every store
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			test(t, store)
+		})
+	}
+}
+
+// newUser creates a user with a unique username and email
+func newUser(t *testing.T, store Store) *models.User {
+	t.Helper()
+	id := uuid.NewString()
+	email := id + "@example.com"
+	user := &models.User{
+		Email:     &email,
+		Username:  "user" + id[:8],
+		Password:  "hash",
+		Id:        id,
+		Verified:  true,
+		CreatedAt: time.Now().Round(time.Microsecond),
+	}
+	if !store.CreateUser(user) {
+		t.Fatal("unable to create user")
+	}
+	return user
+}
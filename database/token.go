@@ -0,0 +1,104 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/lib/pq"
+)
+
+func (s *PostgresStore) CreateAccessToken(token *models.AccessToken) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO access_tokens(id, user_id, name, scopes, token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		token.Id,
+		token.UserId,
+		token.Name,
+		pq.Array(token.Scopes),
+		token.Hash,
+		token.CreatedAt,
+	); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) ReadAccessTokenByHash(hash string) *models.AccessToken {
+	var token models.AccessToken
+	if err := s.db.QueryRow(
+		`SELECT id, user_id, name, scopes, token_hash, created_at, last_used_at
+		FROM access_tokens WHERE token_hash = $1`,
+		hash,
+	).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.Hash,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+	); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return &token
+}
+
+func (s *PostgresStore) ReadAccessTokens(userId string) []models.AccessToken {
+	var tokens []models.AccessToken
+	rows, err := s.db.Query(
+		`SELECT id, user_id, name, scopes, token_hash, created_at, last_used_at
+		FROM access_tokens WHERE user_id = $1 ORDER BY created_at DESC`,
+		userId,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token models.AccessToken
+		rows.Scan(
+			&token.Id,
+			&token.UserId,
+			&token.Name,
+			pq.Array(&token.Scopes),
+			&token.Hash,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+		)
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (s *PostgresStore) TouchAccessToken(id string, usedAt time.Time) {
+	if _, err := s.db.Exec(
+		`UPDATE access_tokens SET last_used_at = $1 WHERE id = $2`, usedAt, id,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *PostgresStore) DeleteAccessToken(userId string, id string) bool {
+	result, err := s.db.Exec(
+		`DELETE FROM access_tokens WHERE user_id = $1 AND id = $2`, userId, id,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+// DeleteAccessTokens revokes every personal access token of the user
+func (s *PostgresStore) DeleteAccessTokens(userId string) bool {
+	if _, err := s.db.Exec(`DELETE FROM access_tokens WHERE user_id = $1`, userId); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+func TestDeleteAccessTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		for _, userId := range []string{user.Id, user.Id, other.Id} {
+			token := &models.AccessToken{
+				Id:        uuid.NewString(),
+				UserId:    userId,
+				Name:      "token",
+				Scopes:    []string{models.ScopeRead},
+				Hash:      internal.HashToken(uuid.NewString()),
+				CreatedAt: time.Now(),
+			}
+			if !store.CreateAccessToken(token) {
+				t.Fatal("unable to create access token")
+			}
+		}
+		if !store.DeleteAccessTokens(user.Id) {
+			t.Fatal("unable to delete access tokens")
+		}
+		if tokens := store.ReadAccessTokens(user.Id); len(tokens) != 0 {
+			t.Errorf("%d access tokens left", len(tokens))
+		}
+		if tokens := store.ReadAccessTokens(other.Id); len(tokens) != 1 {
+			t.Errorf("other user has %d access tokens, want 1", len(tokens))
+		}
+	})
+}
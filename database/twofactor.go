@@ -0,0 +1,172 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+// CreateTwoFactor starts an enrollment, replacing a pending one but never
+// an enabled one
+func (s *PostgresStore) CreateTwoFactor(twoFactor *models.TwoFactor) bool {
+	result, err := s.db.Exec(
+		`INSERT INTO two_factor(user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, created_at = $3
+		WHERE two_factor.enabled = FALSE`,
+		twoFactor.UserId,
+		twoFactor.Secret,
+		twoFactor.CreatedAt,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+func (s *PostgresStore) ReadTwoFactor(userId string) *models.TwoFactor {
+	var twoFactor models.TwoFactor
+	if err := s.db.QueryRow(
+		`SELECT user_id, secret, enabled, last_step, created_at
+		FROM two_factor WHERE user_id = $1`,
+		userId,
+	).Scan(
+		&twoFactor.UserId,
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastStep,
+		&twoFactor.CreatedAt,
+	); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return nil
+	}
+	return &twoFactor
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userId string, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(
+			`INSERT INTO recovery_codes(user_id, code_hash) VALUES ($1, $2)`, userId, hash,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableTwoFactor confirms a pending enrollment with the step of the first
+// code and stores the recovery codes
+func (s *PostgresStore) EnableTwoFactor(userId string, step int64, codeHashes []string) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(
+		`UPDATE two_factor SET enabled = TRUE, last_step = $2
+		WHERE user_id = $1 AND enabled = FALSE`,
+		userId, step,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return false
+	}
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		log.Println(err)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) UseTOTPStep(userId string, step int64) bool {
+	result, err := s.db.Exec(
+		`UPDATE two_factor SET last_step = $2 WHERE user_id = $1 AND last_step < $2`,
+		userId, step,
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+func (s *PostgresStore) UseRecoveryCode(userId string, codeHash string) bool {
+	result, err := s.db.Exec(
+		`UPDATE recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userId, codeHash, time.Now(),
+	)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+func (s *PostgresStore) ReadRecoveryCodesCount(userId string) int {
+	var count int
+	if err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userId,
+	).Scan(&count); err != nil {
+		log.Println(err)
+	}
+	return count
+}
+
+func (s *PostgresStore) ReplaceRecoveryCodes(userId string, codeHashes []string) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		log.Println(err)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *PostgresStore) DeleteTwoFactor(userId string) bool {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userId); err != nil {
+		log.Println(err)
+		return false
+	}
+	if _, err := tx.Exec(`DELETE FROM two_factor WHERE user_id = $1`, userId); err != nil {
+		log.Println(err)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+func enableTwoFactor(t *testing.T, store Store, userId string, step int64, codeHashes []string) {
+	t.Helper()
+	if !store.CreateTwoFactor(&models.TwoFactor{UserId: userId, Secret: internal.NewTOTPSecret(), CreatedAt: time.Now()}) {
+		t.Fatal("unable to create two-factor")
+	}
+	if !store.EnableTwoFactor(userId, step, codeHashes) {
+		t.Fatal("unable to enable two-factor")
+	}
+}
+
+func TestUseTOTPStepRejectsReplays(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		// The step of the enrollment code is used up
+		enableTwoFactor(t, store, user.Id, 100, nil)
+		if store.UseTOTPStep(user.Id, 100) {
+			t.Error("step of the enrollment was accepted")
+		}
+		if !store.UseTOTPStep(user.Id, 101) {
+			t.Fatal("next step was rejected")
+		}
+		if store.UseTOTPStep(user.Id, 101) {
+			t.Error("replayed step was accepted")
+		}
+		// A code of the previous step is still valid within the skew, but
+		// older than one already used
+		if store.UseTOTPStep(user.Id, 100) {
+			t.Error("earlier step was accepted")
+		}
+		if store.UseTOTPStep(newUser(t, store).Id, 200) {
+			t.Error("step of a user without two-factor was accepted")
+		}
+	})
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		codes := internal.NewRecoveryCodes(2)
+		enableTwoFactor(t, store, user.Id, 1, []string{internal.HashToken(codes[0]), internal.HashToken(codes[1])})
+		if count := store.ReadRecoveryCodesCount(user.Id); count != 2 {
+			t.Fatalf("recovery codes = %d, want 2", count)
+		}
+		if !store.UseRecoveryCode(user.Id, internal.HashToken(codes[0])) {
+			t.Fatal("recovery code was rejected")
+		}
+		if store.UseRecoveryCode(user.Id, internal.HashToken(codes[0])) {
+			t.Error("used recovery code was accepted again")
+		}
+		if store.UseRecoveryCode(newUser(t, store).Id, internal.HashToken(codes[1])) {
+			t.Error("recovery code of another user was accepted")
+		}
+		if count := store.ReadRecoveryCodesCount(user.Id); count != 1 {
+			t.Errorf("recovery codes = %d, want 1", count)
+		}
+		// Replacing the codes invalidates the old ones
+		fresh := internal.NewRecoveryCodes(1)
+		store.ReplaceRecoveryCodes(user.Id, []string{internal.HashToken(fresh[0])})
+		if store.UseRecoveryCode(user.Id, internal.HashToken(codes[1])) {
+			t.Error("replaced recovery code was accepted")
+		}
+		if !store.UseRecoveryCode(user.Id, internal.HashToken(fresh[0])) {
+			t.Error("new recovery code was rejected")
+		}
+	})
+}
@@ -1,23 +1,31 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/lib/pq"
 )
 
-func CreateUser(user *models.User) bool {
-	if _, err := db.Exec(
-		`INSERT INTO t_users(email, username, password, id, verified, avatar, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+// The password is NULL for accounts without one
+const userColumns = `email, username, COALESCE(password, ''), id, verified, avatar, avatar_medium, avatar_small, created_at`
+
+func (s *PostgresStore) CreateUser(user *models.User) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO t_users(email, username, password, id, verified, avatar, avatar_medium, avatar_small, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 		user.Email,
 		user.Username,
-		user.Password,
+		sql.NullString{String: user.Password, Valid: user.HasPassword()},
 		user.Id,
 		user.Verified,
 		user.Avatar,
+		user.AvatarMedium,
+		user.AvatarSmall,
 		user.CreatedAt,
 	); err != nil {
 		log.Println(err)
@@ -26,23 +34,17 @@ func CreateUser(user *models.User) bool {
 	return true
 }
 
-func CreateOAuthUser(id string) bool {
-	if _, err := db.Exec(`INSERT INTO o_users(id) VALUES ($1)`, id); err != nil {
-		log.Println(err)
-		return false
-	}
-	return true
-}
-
-func ReadUserByName(username string) *models.User {
+func (s *PostgresStore) ReadUserByName(username string) *models.User {
 	var user models.User
-	if err := db.QueryRow(`SELECT * FROM t_users WHERE username = $1`, username).Scan(
+	if err := s.db.QueryRow(`SELECT `+userColumns+` FROM t_users WHERE username = $1`, username).Scan(
 		&user.Email,
 		&user.Username,
 		&user.Password,
 		&user.Id,
 		&user.Verified,
 		&user.Avatar,
+		&user.AvatarMedium,
+		&user.AvatarSmall,
 		&user.CreatedAt,
 	); err != nil {
 		log.Println(err)
@@ -51,15 +53,17 @@ func ReadUserByName(username string) *models.User {
 	return &user
 }
 
-func ReadUserByEmail(email string) *models.User {
+func (s *PostgresStore) ReadUserByEmail(email string) *models.User {
 	var user models.User
-	if err := db.QueryRow(`SELECT * FROM t_users WHERE email = $1`, email).Scan(
+	if err := s.db.QueryRow(`SELECT `+userColumns+` FROM t_users WHERE email = $1`, email).Scan(
 		&user.Email,
 		&user.Username,
 		&user.Password,
 		&user.Id,
 		&user.Verified,
 		&user.Avatar,
+		&user.AvatarMedium,
+		&user.AvatarSmall,
 		&user.CreatedAt,
 	); err != nil {
 		log.Println(err)
@@ -68,15 +72,17 @@ func ReadUserByEmail(email string) *models.User {
 	return &user
 }
 
-func ReadUserById(id string) *models.User {
+func (s *PostgresStore) ReadUserById(id string) *models.User {
 	var user models.User
-	if err := db.QueryRow(`SELECT * FROM t_users WHERE id = $1`, id).Scan(
+	if err := s.db.QueryRow(`SELECT `+userColumns+` FROM t_users WHERE id = $1`, id).Scan(
 		&user.Email,
 		&user.Username,
 		&user.Password,
 		&user.Id,
 		&user.Verified,
 		&user.Avatar,
+		&user.AvatarMedium,
+		&user.AvatarSmall,
 		&user.CreatedAt,
 	); err != nil {
 		log.Println(err)
@@ -85,23 +91,18 @@ func ReadUserById(id string) *models.User {
 	return &user
 }
 
-func IsOAuthUser(id string) bool {
-	var count int
-	db.QueryRow(`SELECT COUNT(*) FROM o_users WHERE id = $1`, id).Scan(&count)
-	switch count {
-	case 0:
-		return false
-	default:
-		return true
-	}
-}
-
-func ReadUsers(username string, limit int, offset int) []models.User {
+func (s *PostgresStore) ReadUsers(username string, limit int, cursor *models.Cursor) []models.User {
 	var users []models.User
-	rows, err := db.Query(
-		`SELECT * FROM t_users WHERE username LIKE $1 ORDER BY username
-		LIMIT $2 OFFSET $3`,
-		"%"+username+"%", limit, offset)
+	condition, args := keyset(cursor, 3)
+	rows, err := s.db.Query(
+		fmt.Sprintf(
+			`SELECT %s FROM t_users WHERE username LIKE $1 %s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2`,
+			userColumns, condition,
+		),
+		append([]any{"%" + username + "%", limit}, args...)...,
+	)
 	if err != nil {
 		log.Println(err)
 		return nil
@@ -116,6 +117,8 @@ func ReadUsers(username string, limit int, offset int) []models.User {
 			&user.Id,
 			&user.Verified,
 			&user.Avatar,
+			&user.AvatarMedium,
+			&user.AvatarSmall,
 			&user.CreatedAt,
 		)
 		users = append(users, user)
@@ -123,30 +126,58 @@ func ReadUsers(username string, limit int, offset int) []models.User {
 	return users
 }
 
-func UpdateUser(id string, updates map[string]any) bool {
+// UpdateUser sets all the columns in one statement, so that either all of
+// them change or none
+func (s *PostgresStore) UpdateUser(id string, updates map[string]any) bool {
+	if len(updates) == 0 {
+		return true
+	}
+	columns := make([]string, 0, len(updates))
 	for column := range updates {
-		if _, err := db.Exec(
-			fmt.Sprintf(`UPDATE t_users SET %s = $1 WHERE id = $2`, pq.QuoteIdentifier(column)),
-			updates[column], id,
-		); err != nil {
-			log.Println(err)
-			return false
-		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	assignments := make([]string, len(columns))
+	args := make([]any, len(columns), len(columns)+1)
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(column), i+1)
+		args[i] = updates[column]
+	}
+	args = append(args, id)
+	if _, err := s.db.Exec(
+		fmt.Sprintf(`UPDATE t_users SET %s WHERE id = $%d`, strings.Join(assignments, ", "), len(args)),
+		args...,
+	); err != nil {
+		log.Println(err)
+		return false
 	}
 	return true
 }
 
-func DeleteUser(id string) bool {
-	if _, err := db.Exec(`DELETE FROM t_users WHERE id = $1`, id); err != nil {
+func (s *PostgresStore) DeleteUser(id string) bool {
+	if _, err := s.db.Exec(`DELETE FROM t_users WHERE id = $1`, id); err != nil {
 		log.Println(err)
 		return false
 	}
 	return true
 }
 
-func Followed(userId string, followId string) bool {
+func (s *PostgresStore) AvatarInUse(url string) bool {
+	var exists bool
+	if err := s.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM t_users WHERE avatar = $1 OR avatar_medium = $1 OR avatar_small = $1)`,
+		url,
+	).Scan(&exists); err != nil {
+		log.Println(err)
+		// Keep the blob if unsure
+		return true
+	}
+	return exists
+}
+
+func (s *PostgresStore) Followed(userId string, followId string) bool {
 	var count int
-	db.QueryRow(
+	s.db.QueryRow(
 		`SELECT COUNT(*) FROM follows WHERE user_id = $1 AND follow_id = $2`,
 		userId, followId,
 	).Scan(&count)
@@ -159,9 +190,9 @@ func Followed(userId string, followId string) bool {
 	}
 }
 
-func ToggleFollow(userId string, followId string) {
+func (s *PostgresStore) ToggleFollow(userId string, followId string) {
 	var query string
-	voted := Followed(userId, followId)
+	voted := s.Followed(userId, followId)
 
 	switch voted {
 	case false:
@@ -169,14 +200,14 @@ func ToggleFollow(userId string, followId string) {
 	default:
 		query = `DELETE FROM follows WHERE user_id = $1 AND follow_id = $2`
 	}
-	if _, err := db.Exec(query, userId, followId); err != nil {
+	if _, err := s.db.Exec(query, userId, followId); err != nil {
 		log.Println(err)
 	}
 }
 
-func ReadFollowers(userId string) []string {
+func (s *PostgresStore) ReadFollowers(userId string) []string {
 	var followers []string
-	rows, err := db.Query(
+	rows, err := s.db.Query(
 		`SELECT username FROM t_users WHERE id in
 		(SELECT user_id FROM follows WHERE follow_id = $1)`,
 		userId,
@@ -195,9 +226,9 @@ func ReadFollowers(userId string) []string {
 	return followers
 }
 
-func ReadFollowersCount(userId string) int {
+func (s *PostgresStore) ReadFollowersCount(userId string) int {
 	var count int
-	if err := db.QueryRow(
+	if err := s.db.QueryRow(
 		`SELECT COUNT(*) FROM t_users WHERE id in
 		(SELECT user_id FROM follows WHERE follow_id = $1)`,
 		userId,
@@ -207,9 +238,9 @@ func ReadFollowersCount(userId string) int {
 	return count
 }
 
-func ReadFollowing(userId string) []string {
+func (s *PostgresStore) ReadFollowing(userId string) []string {
 	var followers []string
-	rows, err := db.Query(
+	rows, err := s.db.Query(
 		`SELECT username FROM t_users WHERE id in
 		(SELECT follow_id FROM follows WHERE user_id = $1)`,
 		userId,
@@ -228,9 +259,9 @@ func ReadFollowing(userId string) []string {
 	return followers
 }
 
-func ReadFollowingCount(userId string) int {
+func (s *PostgresStore) ReadFollowingCount(userId string) int {
 	var count int
-	if err := db.QueryRow(
+	if err := s.db.QueryRow(
 		`SELECT COUNT(*) FROM t_users WHERE id in
 		(SELECT follow_id FROM follows WHERE user_id = $1)`,
 		userId,
@@ -240,9 +271,10 @@ func ReadFollowingCount(userId string) int {
 	return count
 }
 
-func CreateVerificationId(token string, id string) bool {
-	if _, err := db.Exec(
-		`INSERT INTO shorturl(token, id) VALUES ($1, $2)`, token, id,
+func (s *PostgresStore) CreateVerificationId(token string, id string, userId string, purpose string) bool {
+	if _, err := s.db.Exec(
+		`INSERT INTO shorturl(token, id, user_id, purpose) VALUES ($1, $2, $3, $4)`,
+		token, id, userId, purpose,
 	); err != nil {
 		log.Println(err)
 		return false
@@ -250,9 +282,9 @@ func CreateVerificationId(token string, id string) bool {
 	return true
 }
 
-func ReadVerificationId(id string) string {
+func (s *PostgresStore) ReadVerificationId(id string) string {
 	var token string
-	if err := db.QueryRow(
+	if err := s.db.QueryRow(
 		`SELECT token FROM shorturl WHERE id = $1`, id,
 	).Scan(&token); err != nil {
 		log.Println(err)
@@ -261,8 +293,25 @@ func ReadVerificationId(id string) string {
 	return token
 }
 
-func DeleteVerificationId(id string) bool {
-	if _, err := db.Exec(`DELETE FROM shorturl WHERE id = $1`, id); err != nil {
+// DeleteVerificationId reports whether the id existed, so that only one
+// request can consume it
+func (s *PostgresStore) DeleteVerificationId(id string) bool {
+	result, err := s.db.Exec(`DELETE FROM shorturl WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count > 0
+}
+
+// DeleteVerificationIds revokes the pending links of the user for the
+// purposes
+func (s *PostgresStore) DeleteVerificationIds(userId string, purposes []string) bool {
+	if _, err := s.db.Exec(
+		`DELETE FROM shorturl WHERE user_id = $1 AND purpose = ANY($2)`,
+		userId, pq.Array(purposes),
+	); err != nil {
 		log.Println(err)
 		return false
 	}
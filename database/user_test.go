@@ -0,0 +1,218 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+func TestCreateUserUnique(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		id := uuid.NewString()
+		email := id + "@example.com"
+		if store.CreateUser(&models.User{Email: &email, Username: user.Username, Password: "hash", Id: id, CreatedAt: time.Now()}) {
+			t.Error("user with a taken username was created")
+		}
+		if store.CreateUser(&models.User{Email: user.Email, Username: "user" + id[:8], Password: "hash", Id: id, CreatedAt: time.Now()}) {
+			t.Error("user with a taken email was created")
+		}
+		if store.ReadUserById(id) != nil {
+			t.Error("rejected user was saved")
+		}
+	})
+}
+
+func TestUpdateUserUnique(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		if store.UpdateUser(user.Id, map[string]any{"username": other.Username}) {
+			t.Error("username was changed to a taken one")
+		}
+		if store.UpdateUser(user.Id, map[string]any{"email": *other.Email}) {
+			t.Error("email was changed to a taken one")
+		}
+		if got := store.ReadUserById(user.Id); got.Username != user.Username || *got.Email != *user.Email {
+			t.Errorf("user = %s %s, want %s %s", got.Username, *got.Email, user.Username, *user.Email)
+		}
+		// Keeping the own username and email is not a conflict
+		if !store.UpdateUser(user.Id, map[string]any{"username": user.Username, "email": *user.Email}) {
+			t.Error("unchanged username and email were rejected")
+		}
+		username := "user" + uuid.NewString()[:8]
+		if !store.UpdateUser(user.Id, map[string]any{"username": username}) {
+			t.Fatal("free username was rejected")
+		}
+		if got := store.ReadUserByName(username); got == nil || got.Id != user.Id {
+			t.Error("user wasn't found by the new username")
+		}
+	})
+}
+
+func TestDeleteUserCascades(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		now := time.Now().Round(time.Microsecond)
+		post := &models.Post{Id: uuid.NewString(), Body: "post", CreatedAt: now}
+		otherPost := &models.Post{Id: uuid.NewString(), Body: "other post", CreatedAt: now}
+		if !store.CreatePost(user.Id, post) || !store.CreatePost(other.Id, otherPost) {
+			t.Fatal("unable to create posts")
+		}
+		// Comments of the user on someone else's post, and of someone else
+		// on the user's post
+		comment := &models.Comment{Id: uuid.NewString(), Body: "comment", CreatedAt: now}
+		otherComment := &models.Comment{Id: uuid.NewString(), Body: "other comment", CreatedAt: now}
+		if !store.CreateComment(user.Id, otherPost.Id, comment) || !store.CreateComment(other.Id, post.Id, otherComment) {
+			t.Fatal("unable to create comments")
+		}
+		store.ToggleVote(user.Id, otherPost.Id)
+		store.ToggleVote(other.Id, post.Id)
+		store.ToggleFollow(user.Id, other.Id)
+		store.ToggleFollow(other.Id, user.Id)
+		token := &models.AccessToken{Id: uuid.NewString(), UserId: user.Id, Name: "token", Scopes: []string{"read"}, Hash: internal.HashToken(uuid.NewString()), CreatedAt: now}
+		if !store.CreateAccessToken(token) {
+			t.Fatal("unable to create access token")
+		}
+		session := &models.Session{Id: uuid.NewString(), UserId: user.Id, RefreshHash: internal.HashToken(uuid.NewString()), CreatedAt: now, LastSeenAt: now, RotatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if !store.CreateSession(session) {
+			t.Fatal("unable to create session")
+		}
+		enableTwoFactor(t, store, user.Id, 1, []string{internal.HashToken("code")})
+
+		if !store.DeleteUser(user.Id) {
+			t.Fatal("unable to delete user")
+		}
+		if store.ReadUserById(user.Id) != nil {
+			t.Error("user wasn't deleted")
+		}
+		if store.ReadPost(post.Id) != nil {
+			t.Error("post of the user wasn't deleted")
+		}
+		if store.ReadComment(comment.Id) != nil {
+			t.Error("comment of the user wasn't deleted")
+		}
+		if store.ReadComment(otherComment.Id) != nil {
+			t.Error("comment on a post of the user wasn't deleted")
+		}
+		if len(store.ReadVotes(otherPost.Id)) != 0 {
+			t.Error("vote of the user wasn't deleted")
+		}
+		if store.ReadFollowersCount(other.Id) != 0 || store.ReadFollowingCount(other.Id) != 0 {
+			t.Error("follows of the user weren't deleted")
+		}
+		if store.ReadAccessTokenByHash(token.Hash) != nil {
+			t.Error("access token of the user wasn't deleted")
+		}
+		if store.ReadSession(session.Id) != nil {
+			t.Error("session of the user wasn't deleted")
+		}
+		if store.ReadTwoFactor(user.Id) != nil || store.ReadRecoveryCodesCount(user.Id) != 0 {
+			t.Error("two-factor of the user wasn't deleted")
+		}
+		if store.ReadUserById(other.Id) == nil || store.ReadPost(otherPost.Id) == nil {
+			t.Error("other user was deleted")
+		}
+	})
+}
+
+func TestDeleteVerificationIdOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		id, token := uuid.NewString()[:8], uuid.NewString()
+		if !store.CreateVerificationId(token, id, user.Id, "verify") {
+			t.Fatal("unable to create verification id")
+		}
+		if got := store.ReadVerificationId(id); got != token {
+			t.Errorf("token = %q, want %q", got, token)
+		}
+		if !store.DeleteVerificationId(id) {
+			t.Fatal("verification id wasn't deleted")
+		}
+		if store.DeleteVerificationId(id) {
+			t.Error("verification id was deleted twice")
+		}
+		if got := store.ReadVerificationId(id); got != "" {
+			t.Errorf("token = %q after deletion", got)
+		}
+	})
+}
+
+func TestDeleteVerificationIds(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		links := map[string]string{}
+		for _, link := range []struct{ userId, purpose string }{
+			{user.Id, "email"}, {user.Id, "reset"}, {user.Id, "verify"}, {other.Id, "reset"},
+		} {
+			id := uuid.NewString()
+			if !store.CreateVerificationId(uuid.NewString(), id, link.userId, link.purpose) {
+				t.Fatal("unable to create verification id")
+			}
+			links[link.userId[:8]+link.purpose] = id
+		}
+		if !store.DeleteVerificationIds(user.Id, []string{"email", "reset"}) {
+			t.Fatal("unable to delete verification ids")
+		}
+		for key, id := range links {
+			kept := key == user.Id[:8]+"verify" || key == other.Id[:8]+"reset"
+			if got := store.ReadVerificationId(id) != ""; got != kept {
+				t.Errorf("%s kept = %v, want %v", key, got, kept)
+			}
+		}
+		// Links go with their user
+		store.DeleteUser(other.Id)
+		if store.ReadVerificationId(links[other.Id[:8]+"reset"]) != "" {
+			t.Error("link of a deleted user was kept")
+		}
+	})
+}
+
+func TestUpdateUserAtomic(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, other := newUser(t, store), newUser(t, store)
+		email := uuid.NewString() + "@example.com"
+		// The taken username rejects the whole update
+		if store.UpdateUser(user.Id, map[string]any{
+			"email":    email,
+			"verified": false,
+			"username": other.Username,
+		}) {
+			t.Fatal("update with a taken username succeeded")
+		}
+		got := store.ReadUserById(user.Id)
+		if *got.Email != *user.Email || !got.Verified || got.Username != user.Username {
+			t.Errorf("user = %s %v %s after a rejected update", *got.Email, got.Verified, got.Username)
+		}
+		if !store.UpdateUser(user.Id, map[string]any{"email": email, "verified": false}) {
+			t.Fatal("unable to update user")
+		}
+		if got := store.ReadUserById(user.Id); *got.Email != email || got.Verified {
+			t.Errorf("user = %s %v, want %s false", *got.Email, got.Verified, email)
+		}
+	})
+}
+
+func TestAvatarInUse(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := newUser(t, store)
+		url := "http://localhost:8080/media/avatars/" + uuid.NewString() + ".png"
+		if store.AvatarInUse(url) {
+			t.Fatal("unused avatar in use")
+		}
+		if !store.UpdateUser(user.Id, map[string]any{"avatar_small": url}) {
+			t.Fatal("unable to update user")
+		}
+		if !store.AvatarInUse(url) {
+			t.Error("avatar shown by a user not in use")
+		}
+		if !store.DeleteUser(user.Id) {
+			t.Fatal("unable to delete user")
+		}
+		if store.AvatarInUse(url) {
+			t.Error("avatar of a deleted user in use")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package docs
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Devansh3712/tsuki-go/routes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handlers of the HTML pages that respond with JSON to the method. Together
+// with every handler of the api package they must be documented, wherever
+// they are registered.
+var jsonHandlers = []struct {
+	Method  string
+	Handler gin.HandlerFunc
+}{
+	{"GET", routes.LoadMoreFeed},
+	{"GET", routes.LoadMorePosts},
+	{"GET", routes.LoadMoreComments},
+	{"POST", routes.SearchUser},
+	{"GET", routes.LoadMoreUsers},
+	{"POST", routes.BeginPasskeyRegistration},
+	{"POST", routes.FinishPasskeyRegistration},
+	{"POST", routes.BeginPasskeyLogin},
+	{"POST", routes.FinishPasskeyLogin},
+}
+
+// respondsWithJSON tells if the handler of a route responds with JSON
+func respondsWithJSON(route gin.RouteInfo) bool {
+	if strings.HasPrefix(route.Handler, reflect.TypeOf(errorResponse).PkgPath()+".") {
+		return true
+	}
+	for _, json := range jsonHandlers {
+		if route.Method == json.Method && route.Handler == handlerName(json.Handler) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check validates the document against the registered routes. Every route
+// served by a JSON handler must be documented, every documented operation
+// must be registered with the same handler, and the committed document must
+// match the current Go types.
+func Check(routes gin.RoutesInfo) []error {
+	var errs []error
+	registered := make(map[string]gin.RouteInfo)
+	for _, route := range routes {
+		registered[route.Method+" "+route.Path] = route
+	}
+	documented := make(map[string]bool)
+	for _, op := range operations {
+		key := op.Method + " " + op.Path
+		documented[key] = true
+		route, ok := registered[key]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s is documented but not registered", key))
+		case route.Handler != op.handlerName():
+			errs = append(errs, fmt.Errorf("%s is handled by %s, documented as %s", key, route.Handler, op.handlerName()))
+		}
+	}
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		if respondsWithJSON(route) && !documented[key] {
+			errs = append(errs, fmt.Errorf("%s is not documented", key))
+		}
+	}
+	generated, err := Generate()
+	if err != nil {
+		return append(errs, err)
+	}
+	if !bytes.Equal(generated, document) {
+		errs = append(errs, fmt.Errorf("openapi.json does not match the handler types, run `tsuki-go openapi generate`"))
+	}
+	return errs
+}
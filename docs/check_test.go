@@ -0,0 +1,41 @@
+package docs
+
+import (
+	"testing"
+
+	"github.com/Devansh3712/tsuki-go/api"
+	"github.com/Devansh3712/tsuki-go/routes"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckFlagsUndocumentedJSONRoutes(t *testing.T) {
+	var registered gin.RoutesInfo
+	for _, op := range operations {
+		registered = append(registered, gin.RouteInfo{Method: op.Method, Path: op.Path, Handler: op.handlerName()})
+	}
+	extra := []struct {
+		route   gin.RouteInfo
+		flagged bool
+	}{
+		// JSON handlers are found by handler, not by path
+		{gin.RouteInfo{Method: "POST", Path: "/passkey/begin", Handler: handlerName(routes.BeginPasskeyLogin)}, true},
+		{gin.RouteInfo{Method: "GET", Path: "/v2/user", Handler: handlerName(api.GetCurrentUser)}, true},
+		// The same handler renders a page on GET
+		{gin.RouteInfo{Method: "GET", Path: "/search/", Handler: handlerName(routes.SearchUser)}, false},
+		{gin.RouteInfo{Method: "GET", Path: "/user/settings/passkeys", Handler: handlerName(routes.Passkeys)}, false},
+	}
+	for _, test := range extra {
+		errs := Check(append(registered, test.route))
+		flagged := false
+		for _, err := range errs {
+			if err.Error() == test.route.Method+" "+test.route.Path+" is not documented" {
+				flagged = true
+			} else {
+				t.Errorf("%s %s: unexpected error %v", test.route.Method, test.route.Path, err)
+			}
+		}
+		if flagged != test.flagged {
+			t.Errorf("%s %s flagged = %v, want %v", test.route.Method, test.route.Path, flagged, test.flagged)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package docs generates the OpenAPI document of the JSON endpoints from
+// the operations table and the Go types the handlers respond with.
+package docs
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Generated document, kept in the repository so that changes to the API
+// show up in review. Regenerate it with `tsuki-go openapi generate`.
+//
+//go:embed openapi.json
+var document []byte
+
+// Serve the committed OpenAPI document
+func Serve(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", document)
+}
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+// Convert a gin path like /posts/:id to the OpenAPI form /posts/{id}
+func openAPIPath(path string) string {
+	return pathParam.ReplaceAllString(path, "{$1}")
+}
+
+type generator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the JSON schema of a type following the encoding/json
+// rules, named structs are added to the components and referenced
+func (g *generator) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		// Bytes are encoded as base64, WebAuthn types use the URL alphabet
+		return map[string]any{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Pointer:
+		schema := g.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = t.Name()
+			// Qualify types sharing a name with the package name
+			if _, taken := g.schemas[name]; taken {
+				name = path.Base(t.PkgPath()) + name
+			}
+			g.names[t] = name
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of a struct, flattening embedded structs the
+// way encoding/json does
+func (g *generator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for index := 0; index < t.NumField(); index++ {
+			field := t.Field(index)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schema(field.Type)
+			if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	collect(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (g *generator) operation(op operation) map[string]any {
+	result := map[string]any{
+		"summary":     op.Summary,
+		"operationId": op.handlerName()[strings.LastIndex(op.handlerName(), ".")+1:],
+		"tags":        []string{op.Tag},
+	}
+	var parameters []any
+	for _, match := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		parameters = append(parameters, map[string]any{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	for _, name := range op.Query {
+		parameters = append(parameters, map[string]any{
+			"name":   name,
+			"in":     "query",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if parameters != nil {
+		result["parameters"] = parameters
+	}
+	if op.Request != nil {
+		contentType := "application/json"
+		if op.Form {
+			contentType = "application/x-www-form-urlencoded"
+		}
+		result["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				contentType: map[string]any{"schema": g.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+	response := map[string]any{"description": http.StatusText(op.Status)}
+	if op.Response != nil {
+		response["content"] = map[string]any{
+			"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(op.Response))},
+		}
+	}
+	responses := map[string]any{strconv.Itoa(op.Status): response}
+	if strings.HasPrefix(op.Path, "/api/") {
+		responses["default"] = map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(errorResponse))},
+			},
+		}
+	}
+	result["responses"] = responses
+	switch op.Auth {
+	case bearerAuth, cookieAuth:
+		result["security"] = []any{map[string]any{string(op.Auth): []string{}}}
+	case optionalBearerAuth:
+		result["security"] = []any{map[string]any{}, map[string]any{string(bearerAuth): []string{}}}
+	}
+	return result
+}
+
+// Generate builds the OpenAPI document from the operations table
+func Generate() ([]byte, error) {
+	g := &generator{
+		schemas: make(map[string]any),
+		names:   make(map[reflect.Type]string),
+	}
+	paths := make(map[string]any)
+	for _, op := range operations {
+		item, ok := paths[openAPIPath(op.Path)].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[openAPIPath(op.Path)] = item
+		}
+		item[strings.ToLower(op.Method)] = g.operation(op)
+	}
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Tsuki",
+			"description": "JSON endpoints of Tsuki, a minimalistic social media platform.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				string(bearerAuth): map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Access token from /api/v1/auth/token, or a personal access token created in the user settings.",
+				},
+				string(cookieAuth): map[string]any{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "tsuki",
+				},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,225 @@
+package docs
+
+import (
+	"reflect"
+	"runtime"
+
+	"github.com/Devansh3712/tsuki-go/api"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/Devansh3712/tsuki-go/routes"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+type security string
+
+const (
+	noAuth             security = ""
+	bearerAuth         security = "bearerAuth"
+	optionalBearerAuth security = "optionalBearerAuth"
+	cookieAuth         security = "cookieAuth"
+)
+
+var errorResponse = api.ErrorResponse{}
+
+// operation documents a JSON endpoint. Request and Response are zero values
+// of the bodies, their schemas are generated through reflection.
+type operation struct {
+	Method   string
+	Path     string
+	Handler  gin.HandlerFunc
+	Summary  string
+	Tag      string
+	Auth     security
+	Query    []string
+	Form     bool
+	Request  any
+	Status   int
+	Response any
+}
+
+func (op operation) handlerName() string {
+	return handlerName(op.Handler)
+}
+
+func handlerName(handler gin.HandlerFunc) string {
+	return runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+}
+
+var operations = []operation{
+	// Versioned API
+	{
+		Method: "POST", Path: "/api/v1/auth/token", Handler: api.CreateToken,
+		Summary: "Log in and create an access token and refresh token", Tag: "auth",
+		Request: api.Credentials{}, Status: 201, Response: api.Token{},
+	},
+	{
+		Method: "POST", Path: "/api/v1/auth/refresh", Handler: api.RefreshToken,
+		Summary: "Exchange a refresh token for a new token pair", Tag: "auth",
+		Request: api.Refresh{}, Status: 200, Response: api.Token{},
+	},
+	{
+		Method: "POST", Path: "/api/v1/auth/revoke", Handler: api.RevokeToken,
+		Summary: "Log out the session of a refresh token", Tag: "auth",
+		Request: api.Refresh{}, Status: 204,
+	},
+	{
+		Method: "GET", Path: "/api/v1/user", Handler: api.GetCurrentUser,
+		Summary: "Get the authenticated user", Tag: "users", Auth: bearerAuth,
+		Status: 200, Response: api.Profile{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/users", Handler: api.SearchUsers,
+		Summary: "Search users by username", Tag: "search", Auth: optionalBearerAuth,
+		Query: []string{"q", "cursor"}, Status: 200, Response: api.ProfilePage{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/users/:username", Handler: api.GetUser,
+		Summary: "Get a user", Tag: "users", Auth: optionalBearerAuth,
+		Status: 200, Response: api.Profile{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/users/:username/posts", Handler: api.GetUserPosts,
+		Summary: "List the posts of a user", Tag: "posts", Auth: optionalBearerAuth,
+		Query: []string{"cursor"}, Status: 200, Response: api.PostPage{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/users/:username/followers", Handler: api.GetFollowers,
+		Summary: "List the followers of a user", Tag: "follows", Auth: optionalBearerAuth,
+		Status: 200, Response: api.Followers{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/users/:username/following", Handler: api.GetFollowing,
+		Summary: "List the users a user follows", Tag: "follows", Auth: optionalBearerAuth,
+		Status: 200, Response: api.Following{},
+	},
+	{
+		Method: "PUT", Path: "/api/v1/users/:username/follow", Handler: api.Follow,
+		Summary: "Follow a user", Tag: "follows", Auth: bearerAuth,
+		Status: 200, Response: api.Profile{},
+	},
+	{
+		Method: "DELETE", Path: "/api/v1/users/:username/follow", Handler: api.Unfollow,
+		Summary: "Unfollow a user", Tag: "follows", Auth: bearerAuth,
+		Status: 200, Response: api.Profile{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/feed", Handler: api.GetFeed,
+		Summary: "List posts of followed users", Tag: "feed", Auth: bearerAuth,
+		Query: []string{"cursor"}, Status: 200, Response: api.PostPage{},
+	},
+	{
+		Method: "POST", Path: "/api/v1/posts", Handler: api.CreatePost,
+		Summary: "Create a post", Tag: "posts", Auth: bearerAuth,
+		Request: api.NewPost{}, Status: 201, Response: models.Post{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/posts/:id", Handler: api.GetPost,
+		Summary: "Get a post", Tag: "posts", Auth: optionalBearerAuth,
+		Status: 200, Response: models.Post{},
+	},
+	{
+		Method: "PATCH", Path: "/api/v1/posts/:id", Handler: api.EditPost,
+		Summary: "Edit the body of a post within the edit window", Tag: "posts", Auth: bearerAuth,
+		Request: api.PostEdit{}, Status: 200, Response: models.Post{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/posts/:id/revisions", Handler: api.GetRevisions,
+		Summary: "List the previous bodies of a post", Tag: "posts", Auth: optionalBearerAuth,
+		Status: 200, Response: api.Revisions{},
+	},
+	{
+		Method: "DELETE", Path: "/api/v1/posts/:id", Handler: api.DeletePost,
+		Summary: "Delete a post", Tag: "posts", Auth: bearerAuth,
+		Status: 204,
+	},
+	{
+		Method: "GET", Path: "/api/v1/posts/:id/votes", Handler: api.GetVotes,
+		Summary: "List the voters of a post", Tag: "votes", Auth: optionalBearerAuth,
+		Status: 200, Response: api.Votes{},
+	},
+	{
+		Method: "PUT", Path: "/api/v1/posts/:id/vote", Handler: api.Vote,
+		Summary: "Vote on a post", Tag: "votes", Auth: bearerAuth,
+		Status: 200, Response: api.Votes{},
+	},
+	{
+		Method: "DELETE", Path: "/api/v1/posts/:id/vote", Handler: api.Unvote,
+		Summary: "Remove a vote from a post", Tag: "votes", Auth: bearerAuth,
+		Status: 200, Response: api.Votes{},
+	},
+	{
+		Method: "GET", Path: "/api/v1/posts/:id/comments", Handler: api.GetComments,
+		Summary: "List the comments on a post", Tag: "comments", Auth: optionalBearerAuth,
+		Query: []string{"cursor"}, Status: 200, Response: api.CommentPage{},
+	},
+	{
+		Method: "POST", Path: "/api/v1/posts/:id/comments", Handler: api.CreateComment,
+		Summary: "Comment on a post", Tag: "comments", Auth: bearerAuth,
+		Request: api.NewComment{}, Status: 201, Response: models.Comment{},
+	},
+	{
+		Method: "DELETE", Path: "/api/v1/posts/:id/comments/:commentId", Handler: api.DeleteComment,
+		Summary: "Delete a comment", Tag: "comments", Auth: bearerAuth,
+		Status: 204,
+	},
+	// AJAX endpoints of the HTML pages
+	{
+		Method: "GET", Path: "/feed/more", Handler: routes.LoadMoreFeed,
+		Summary: "Load the next page of the feed", Tag: "web", Auth: cookieAuth,
+		Query: []string{"cursor"}, Status: 200, Response: api.PostPage{},
+	},
+	{
+		Method: "GET", Path: "/user/:username/posts/more", Handler: routes.LoadMorePosts,
+		Summary: "Load the next page of posts of a user", Tag: "web",
+		Query: []string{"cursor"}, Status: 200, Response: api.PostPage{},
+	},
+	{
+		Method: "GET", Path: "/post/:id/comments", Handler: routes.LoadMoreComments,
+		Summary: "Load the next page of comments on a post", Tag: "web", Auth: cookieAuth,
+		Query: []string{"cursor"}, Status: 200, Response: api.CommentPage{},
+	},
+	{
+		Method: "POST", Path: "/search/", Handler: routes.SearchUser,
+		Summary: "Search users and store the keyword in the session", Tag: "web",
+		Form: true, Request: SearchForm{}, Status: 200, Response: api.ProfilePage{},
+	},
+	{
+		Method: "GET", Path: "/search/more", Handler: routes.LoadMoreUsers,
+		Summary: "Load the next page of the last search", Tag: "web",
+		Query: []string{"cursor"}, Status: 200, Response: api.ProfilePage{},
+	},
+	// Passkey ceremonies, the bodies are passed to and from the WebAuthn API
+	// of the browser
+	{
+		Method: "POST", Path: "/user/settings/passkeys/begin", Handler: routes.BeginPasskeyRegistration,
+		Summary: "Get the options to register a passkey", Tag: "passkeys", Auth: cookieAuth,
+		Status: 200, Response: protocol.CredentialCreation{},
+	},
+	{
+		Method: "POST", Path: "/user/settings/passkeys/finish", Handler: routes.FinishPasskeyRegistration,
+		Summary: "Save the passkey created by the browser under a name", Tag: "passkeys", Auth: cookieAuth,
+		Query: []string{"name"}, Request: protocol.CredentialCreationResponse{}, Status: 201, Response: Redirect{},
+	},
+	{
+		Method: "POST", Path: "/auth/passkey/begin", Handler: routes.BeginPasskeyLogin,
+		Summary: "Get the options to log in with a passkey", Tag: "passkeys",
+		Status: 200, Response: protocol.CredentialAssertion{},
+	},
+	{
+		Method: "POST", Path: "/auth/passkey/finish", Handler: routes.FinishPasskeyLogin,
+		Summary: "Log in with the assertion signed by a passkey", Tag: "passkeys",
+		Request: protocol.CredentialAssertionResponse{}, Status: 200, Response: Redirect{},
+	},
+}
+
+// Redirect tells the script where to go once a passkey ceremony is done
+type Redirect struct {
+	Redirect string `json:"redirect"`
+}
+
+type SearchForm struct {
+	Search string `json:"search"`
+	// Scripts send the token in the X-CSRF-Token header instead
+	CSRFToken string `json:"csrf_token,omitempty"`
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/gin-gonic/gin"
+)
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// Reset mails are throttled per email whatever its case or the client, and
+// per client whatever the email
+func TestForgotPasswordRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newRouter(database.NewMemoryStore(), database.NewMemoryRateLimitStore(), media.NewLocalStore(t.TempDir(), "/media"))
+
+	forgot := func(ip string, email string) int {
+		page := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/auth/forgot", nil)
+		app.ServeHTTP(page, request)
+		token := csrfField.FindStringSubmatch(page.Body.String())
+		if token == nil {
+			t.Fatal("no CSRF token on the page")
+		}
+		form := url.Values{"csrf_token": {token[1]}, "email": {email}}
+		response := httptest.NewRecorder()
+		request = httptest.NewRequest("POST", "/auth/forgot", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.RemoteAddr = ip + ":1234"
+		for _, cookie := range page.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+		app.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if status := forgot(ip, "alice@example.com"); status != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, status)
+		}
+	}
+	if status := forgot("192.0.2.4", " Alice@Example.com "); status != http.StatusTooManyRequests {
+		t.Errorf("fourth mail to the email: status = %d, want 429", status)
+	}
+	for i := 0; i < 9; i++ {
+		forgot("192.0.2.1", "user"+string(rune('a'+i))+"@example.com")
+	}
+	if status := forgot("192.0.2.1", "bob@example.com"); status != http.StatusTooManyRequests {
+		t.Errorf("eleventh request of the client: status = %d, want 429", status)
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+)
+
+// ProviderInfo describes a configured provider for the login page
+type ProviderInfo struct {
+	Name        string
+	DisplayName string
+	Icon        string
+}
+
+type registration struct {
+	ProviderInfo
+	provider Provider
+}
+
+// Providers known without an issuer, enabled by setting their client id
+var builtins = map[string]ProviderInfo{
+	"discord": {Name: "discord", DisplayName: "Discord", Icon: "fa-brands fa-discord"},
+	"github":  {Name: "github", DisplayName: "GitHub", Icon: "fa-brands fa-github"},
+	"google":  {Name: "google", DisplayName: "Google", Icon: "fa-brands fa-google"},
+}
+
+const googleIssuer = "https://accounts.google.com"
+
+var providerName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+var providers = make(map[string]*registration)
+var providerOrder []string
+
+func init() {
+	godotenv.Load(".env")
+	for _, name := range providerNames() {
+		registration, err := loadProvider(name)
+		if err != nil {
+			log.Printf("OAuth provider %s disabled: %v", name, err)
+			continue
+		}
+		providers[name] = registration
+		providerOrder = append(providerOrder, name)
+	}
+}
+
+// providerNames lists OAUTH_PROVIDERS, or the built-in providers that have
+// a client id when it isn't set
+func providerNames() []string {
+	var names []string
+	if list := os.Getenv("OAUTH_PROVIDERS"); list != "" {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for _, name := range []string{"discord", "github", "google"} {
+		if os.Getenv(strings.ToUpper(name)+"_CLIENT_ID") != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadProvider reads the <NAME>_* variables of a provider. Providers other
+// than Discord and GitHub are OpenID Connect providers and need an issuer,
+// for example <NAME>_ISSUER=https://gitlab.com
+func loadProvider(name string) (*registration, error) {
+	if !providerName.MatchString(name) {
+		return nil, errors.New("name must only contain lowercase letters and digits")
+	}
+	env := func(key string) string {
+		return os.Getenv(strings.ToUpper(name) + "_" + key)
+	}
+	info, ok := builtins[name]
+	if !ok {
+		info = ProviderInfo{Name: name, DisplayName: name, Icon: "fa-solid fa-right-to-bracket"}
+	}
+	if displayName := env("DISPLAY_NAME"); displayName != "" {
+		info.DisplayName = displayName
+	}
+	config := oauth2.Config{
+		ClientID:     env("CLIENT_ID"),
+		ClientSecret: env("CLIENT_SECRET"),
+		Scopes:       strings.Fields(env("SCOPES")),
+	}
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("%s_CLIENT_ID is not set", strings.ToUpper(name))
+	}
+	var provider Provider
+	switch name {
+	case "discord":
+		provider = newDiscordProvider(config)
+	case "github":
+		provider = newGitHubProvider(config)
+	default:
+		issuer := env("ISSUER")
+		if issuer == "" && name == "google" {
+			issuer = googleIssuer
+		}
+		if issuer == "" {
+			return nil, fmt.Errorf("%s_ISSUER is not set", strings.ToUpper(name))
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{"openid", "profile", "email"}
+		}
+		provider = newOIDCProvider(issuer, config)
+	}
+	return &registration{ProviderInfo: info, provider: provider}, nil
+}
+
+// Providers returns the configured providers in the order they were listed
+func Providers() []ProviderInfo {
+	infos := make([]ProviderInfo, 0, len(providerOrder))
+	for _, name := range providerOrder {
+		infos = append(infos, providers[name].ProviderInfo)
+	}
+	return infos
+}
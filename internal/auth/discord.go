@@ -1,154 +1,42 @@
 package auth
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"net/url"
-	"os"
-	"time"
 
-	"github.com/Devansh3712/tsuki-go/database"
-	"github.com/Devansh3712/tsuki-go/internal"
-	"github.com/Devansh3712/tsuki-go/middleware"
 	"github.com/Devansh3712/tsuki-go/models"
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
 )
 
-func init() {
-	godotenv.Load(".env")
-}
-
-func DiscordSignUp(c *gin.Context) {
-	c.Redirect(http.StatusFound, os.Getenv("DISCORD_SIGNUP_URL"))
-}
-
-func DiscordLogin(c *gin.Context) {
-	c.Redirect(http.StatusFound, os.Getenv("DISCORD_LOGIN_URL"))
-}
+const discordAPI = "https://discord.com/api/v10"
 
-func DiscordAuth(c *gin.Context) {
-	// Retrieve user access token
-	api := "https://discord.com/api/v10"
-	authCode := c.Query("code")
-	data := url.Values{
-		"client_id":     []string{os.Getenv("DISCORD_CLIENT_ID")},
-		"client_secret": []string{os.Getenv("DISCORD_CLIENT_SECRET")},
-		"grant_type":    []string{"authorization_code"},
-		"code":          []string{authCode},
+// Discord has no OpenID Connect discovery, the profile comes from its API
+func newDiscordProvider(config oauth2.Config) *oauthProvider {
+	config.Endpoint = oauth2.Endpoint{
+		AuthURL:  "https://discord.com/oauth2/authorize",
+		TokenURL: discordAPI + "/oauth2/token",
 	}
-	switch c.Query("login") {
-	case "true":
-		data.Add("redirect_uri", "https://tsukigo.herokuapp.com/auth/discord?login=true")
-	default:
-		data.Add("redirect_uri", "https://tsukigo.herokuapp.com/auth/discord")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"identify", "email"}
 	}
-	response, err := http.PostForm(api+"/oauth2/token", data)
-	if err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to retrieve access token, try again later.",
-		})
-		return
-	}
-	defer response.Body.Close()
-	var responseData map[string]interface{}
-	if err := json.NewDecoder(response.Body).Decode(&responseData); err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to parse authorization response, try again later.",
-		})
-		return
-	}
-	accessToken := responseData["access_token"].(string)
-	// Fetch user data
-	request, _ := http.NewRequest("GET", api+"/users/@me", nil)
-	request.Header.Add("Authorization", "Bearer "+accessToken)
-	client := &http.Client{}
-	response, err = client.Do(request)
-	if err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to make authorization request, try again later.",
-		})
-		return
+	return &oauthProvider{config: config, profile: discordProfile}
+}
+
+func discordProfile(ctx context.Context, client *http.Client) (*Profile, error) {
+	var user models.DiscordUser
+	if err := getJSON(ctx, client, discordAPI+"/users/@me", &user); err != nil {
+		return nil, err
 	}
-	defer response.Body.Close()
-	var authUser models.DiscordUser
-	if err := json.NewDecoder(response.Body).Decode(&authUser); err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to parse authorization response, try again later.",
-		})
-		return
+	profile := &Profile{
+		Subject:  user.DiscordId,
+		Username: user.Username,
+		Email:    user.Email,
+		Verified: user.Verified,
 	}
-	// Signup or login user
-	exists := database.ReadUserByEmail(*authUser.Email)
-	log.Println(exists)
-	switch c.Query("login") {
-	case "true":
-		if exists == nil {
-			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
-				"error":   "401 Unauthorized",
-				"message": "User does not exist.",
-			})
-			return
-		}
-		token, _ := middleware.CreateToken(exists.Id)
-		session := sessions.Default(c)
-		session.Set("Authorization", token)
-		session.Save()
-		c.Redirect(http.StatusFound, "/feed")
-	default:
-		if exists != nil {
-			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
-				"error":   "403 Forbidden",
-				"message": "Account already exists with the given email.",
-			})
-			return
-		}
-		var user models.User
-		user.Username = authUser.Username
-		// Update the username if it already exists in the database
-		if result := database.ReadUserByName(user.Username); result != nil {
-			user.Username += internal.RandomString(32 - len(authUser.Username))
-		}
-		user.CreatedAt = time.Now()
-		user.Email = authUser.Email
-		user.Verified = authUser.Verified
-		user.Id = uuid.NewString()
-		// Generate a random password for oauth user
-		user.Password = uuid.NewString()
-		user.HashPassword()
-		if authUser.Avatar != nil {
-			avatar := fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s", authUser.DiscordId, *authUser.Avatar)
-			user.Avatar = &avatar
-		}
-		if res := database.CreateUser(&user); !res {
-			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-				"error":   "400 Bad Request",
-				"message": "Unable to create account, try again later.",
-			})
-			return
-		}
-		// Add to table that identifies OAuth users
-		database.CreateOAuthUser(user.Id)
-		token, _ := middleware.CreateToken(user.Id)
-		session := sessions.Default(c)
-		session.Set("Authorization", token)
-		session.Save()
-		if user.Verified {
-			c.Redirect(http.StatusFound, "/user/")
-		} else {
-			c.Redirect(http.StatusFound, "/auth/verify?signup=true")
-		}
+	if user.Avatar != nil {
+		avatar := fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s", user.DiscordId, *user.Avatar)
+		profile.Avatar = &avatar
 	}
+	return profile, nil
 }
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// The user has this long to consent at the provider
+const authorizationTimeout = 10 * time.Minute
+
+// Usernames are stored in a VARCHAR(32)
+const maxUsernameLength = 32
+
+// Taken usernames get a suffix of this many random characters, drawn again
+// up to maxUsernameAttempts times while the result is taken too
+const (
+	usernameSuffixLength = 5
+	maxUsernameAttempts  = 10
+)
+
+// What the user returning from the provider wants to do
+const (
+	modeSignUp = "signup"
+	modeLogin  = "login"
+	modeLink   = "link"
+)
+
+// authorization is the pending request to a provider kept in the cookie
+type authorization struct {
+	provider string
+	verifier string
+	mode     string
+	userId   string
+}
+
+var authorizationKeys = []string{"oauthProvider", "oauthState", "oauthVerifier", "oauthMode", "oauthUserId", "oauthAt"}
+
+// provider returns the provider named in the route, rendering a 404 if it
+// isn't configured
+func provider(c *gin.Context) (*registration, bool) {
+	registration, ok := providers[c.Param("provider")]
+	if !ok {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Login provider not found.",
+		})
+	}
+	return registration, ok
+}
+
+// The redirect URI to register at the provider
+func redirectURL(name string) string {
+	return internal.BaseURL() + "/auth/" + name
+}
+
+// beginAuthorization remembers the state and PKCE verifier of an
+// authorization request in the cookie, along with what it is for
+func beginAuthorization(c *gin.Context, request authorization) (string, error) {
+	state := internal.NewToken("")
+	session := sessions.Default(c)
+	session.Set("oauthProvider", request.provider)
+	session.Set("oauthState", state)
+	session.Set("oauthVerifier", request.verifier)
+	session.Set("oauthMode", request.mode)
+	session.Set("oauthUserId", request.userId)
+	session.Set("oauthAt", time.Now().Unix())
+	return state, session.Save()
+}
+
+// finishAuthorization checks the state of the callback against the pending
+// authorization request and returns it. The request is forgotten so the
+// callback only works once.
+func finishAuthorization(c *gin.Context, name string) (*authorization, bool) {
+	session := sessions.Default(c)
+	var request authorization
+	request.provider, _ = session.Get("oauthProvider").(string)
+	request.verifier, _ = session.Get("oauthVerifier").(string)
+	request.mode, _ = session.Get("oauthMode").(string)
+	request.userId, _ = session.Get("oauthUserId").(string)
+	state, _ := session.Get("oauthState").(string)
+	startedAt, _ := session.Get("oauthAt").(int64)
+	for _, key := range authorizationKeys {
+		session.Delete(key)
+	}
+	session.Save()
+	ok := state != "" &&
+		request.provider == name &&
+		subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) == 1 &&
+		time.Since(time.Unix(startedAt, 0)) <= authorizationTimeout
+	return &request, ok
+}
+
+func redirect(c *gin.Context, mode string, userId string) {
+	registration, ok := provider(c)
+	if !ok {
+		return
+	}
+	request := authorization{
+		provider: registration.Name,
+		verifier: internal.NewToken(""),
+		mode:     mode,
+		userId:   userId,
+	}
+	state, err := beginAuthorization(c, request)
+	if err != nil {
+		log.Println(err)
+		c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+			"error":   "500 Internal Server Error",
+			"message": "Unable to log in, try again later.",
+		})
+		return
+	}
+	url, err := registration.provider.AuthCodeURL(c.Request.Context(), state, request.verifier, redirectURL(registration.Name))
+	if err != nil {
+		log.Println(err)
+		c.HTML(http.StatusBadGateway, "error.tmpl.html", gin.H{
+			"error":   "502 Bad Gateway",
+			"message": "Unable to reach " + registration.DisplayName + ", try again later.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+func SignUp(c *gin.Context) {
+	redirect(c, modeSignUp, "")
+}
+
+func Login(c *gin.Context) {
+	redirect(c, modeLogin, "")
+}
+
+// Link sends a logged in user to the provider to link an account there
+func Link(c *gin.Context) {
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	redirect(c, modeLink, id.(string))
+}
+
+// Callback signs up, logs in or links the user returning from the provider
+func Callback(c *gin.Context) {
+	registration, ok := provider(c)
+	if !ok {
+		return
+	}
+	request, ok := finishAuthorization(c, registration.Name)
+	if !ok {
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Invalid or expired authorization, try logging in again.",
+		})
+		return
+	}
+	// The user declined or the provider refused the request
+	if reason := c.Query("error"); reason != "" {
+		log.Printf("%s authorization failed: %s %s", registration.Name, reason, c.Query("error_description"))
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": registration.DisplayName + " did not authorize the login.",
+		})
+		return
+	}
+	authUser, err := registration.provider.Exchange(c.Request.Context(), c.Query("code"), request.verifier, redirectURL(registration.Name))
+	if err != nil {
+		log.Println(err)
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Unable to retrieve authorization response, try again later.",
+		})
+		return
+	}
+	switch request.mode {
+	case modeLink:
+		link(c, registration, authUser, request.userId)
+	case modeLogin:
+		login(c, registration, authUser)
+	default:
+		signUp(c, registration, authUser)
+	}
+}
+
+// findIdentity resolves the provider account to the identity linked to it.
+// Accounts created through a provider before identities were recorded are
+// matched once by their verified email and linked.
+func findIdentity(store database.Store, name string, authUser *Profile) *models.Identity {
+	if identity := store.ReadIdentity(name, authUser.Subject); identity != nil {
+		return identity
+	}
+	if authUser.Email == nil || !authUser.Verified {
+		return nil
+	}
+	user := store.ReadUserByEmail(*authUser.Email)
+	if user == nil || user.HasPassword() || len(store.ReadIdentities(user.Id)) > 0 {
+		return nil
+	}
+	identity := newIdentity(name, authUser, user.Id)
+	if result := store.CreateIdentity(identity); !result {
+		return nil
+	}
+	return identity
+}
+
+func newIdentity(name string, authUser *Profile, userId string) *models.Identity {
+	return &models.Identity{
+		Provider:  name,
+		Subject:   authUser.Subject,
+		UserId:    userId,
+		Email:     authUser.Email,
+		CreatedAt: time.Now(),
+	}
+}
+
+func login(c *gin.Context, registration *registration, authUser *Profile) {
+	store := database.Default(c)
+	identity := findIdentity(store, registration.Name, authUser)
+	if identity == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "No account is linked to this " + registration.DisplayName + " account.",
+		})
+		return
+	}
+	store.TouchIdentity(identity.Provider, identity.Subject, time.Now())
+	twoFactor, err := middleware.BeginLogin(c, identity.UserId)
+	if err != nil {
+		log.Println(err)
+		c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+			"error":   "500 Internal Server Error",
+			"message": "Unable to log in, try again later.",
+		})
+		return
+	}
+	if twoFactor {
+		c.Redirect(http.StatusFound, "/auth/login/2fa")
+		return
+	}
+	c.Redirect(http.StatusFound, "/feed")
+}
+
+func signUp(c *gin.Context, registration *registration, authUser *Profile) {
+	store := database.Default(c)
+	// Signing up again with a linked account logs in instead
+	if identity := store.ReadIdentity(registration.Name, authUser.Subject); identity != nil {
+		login(c, registration, authUser)
+		return
+	}
+	if authUser.Email == nil {
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": registration.DisplayName + " did not share an email address.",
+		})
+		return
+	}
+	if exists := store.ReadUserByEmail(*authUser.Email); exists != nil {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Account already exists with the given email, log in and link " + registration.DisplayName + " from the settings.",
+		})
+		return
+	}
+	var user models.User
+	user.Username = uniqueUsername(store, authUser.Username)
+	user.CreatedAt = time.Now()
+	user.Email = authUser.Email
+	user.Verified = authUser.Verified
+	user.Id = uuid.NewString()
+	// The user can set a password later through a mailed link
+	// Providers serve a single size of their avatars
+	user.Avatar = authUser.Avatar
+	user.AvatarMedium = authUser.Avatar
+	user.AvatarSmall = authUser.Avatar
+	if res := store.CreateUser(&user); !res {
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Unable to create account, try again later.",
+		})
+		return
+	}
+	if result := store.CreateIdentity(newIdentity(registration.Name, authUser, user.Id)); !result {
+		store.DeleteUser(user.Id)
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Unable to create account, try again later.",
+		})
+		return
+	}
+	if err := middleware.StartSession(c, user.Id); err != nil {
+		log.Println(err)
+		c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+			"error":   "500 Internal Server Error",
+			"message": "Unable to log in, try again later.",
+		})
+		return
+	}
+	if user.Verified {
+		c.Redirect(http.StatusFound, "/user/")
+	} else {
+		c.Redirect(http.StatusFound, "/auth/verify?signup=true")
+	}
+}
+
+func link(c *gin.Context, registration *registration, authUser *Profile, userId string) {
+	store := database.Default(c)
+	if identity := store.ReadIdentity(registration.Name, authUser.Subject); identity != nil {
+		if identity.UserId == userId {
+			c.Redirect(http.StatusFound, "/user/settings/identities")
+			return
+		}
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "This " + registration.DisplayName + " account is linked to another user.",
+		})
+		return
+	}
+	if result := store.CreateIdentity(newIdentity(registration.Name, authUser, userId)); !result {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Another " + registration.DisplayName + " account is already linked, unlink it first.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/identities")
+}
+
+// uniqueUsername appends a few random characters to the username from the
+// provider while it is already taken. Usernames are cut by characters, not
+// bytes, to fit the column.
+func uniqueUsername(store database.Store, username string) string {
+	if runes := []rune(username); len(runes) > maxUsernameLength {
+		username = string(runes[:maxUsernameLength])
+	}
+	if username != "" && store.ReadUserByName(username) == nil {
+		return username
+	}
+	if runes := []rune(username); len(runes) > maxUsernameLength-usernameSuffixLength {
+		username = string(runes[:maxUsernameLength-usernameSuffixLength])
+	}
+	// Give up after a few attempts, creating the user then fails
+	var candidate string
+	for attempt := 0; attempt < maxUsernameAttempts; attempt++ {
+		candidate = username + internal.RandomString(usernameSuffixLength)
+		if store.ReadUserByName(candidate) == nil {
+			break
+		}
+	}
+	return candidate
+}
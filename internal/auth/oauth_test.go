@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+// takenStore reports the first names looked up as taken
+type takenStore struct {
+	*database.MemoryStore
+	taken   int
+	lookups []string
+}
+
+func (s *takenStore) ReadUserByName(username string) *models.User {
+	s.lookups = append(s.lookups, username)
+	if len(s.lookups) <= s.taken {
+		return &models.User{Username: username}
+	}
+	return s.MemoryStore.ReadUserByName(username)
+}
+
+func createUser(t *testing.T, store database.Store, username string) {
+	t.Helper()
+	if !store.CreateUser(&models.User{Id: uuid.NewString(), Username: username, CreatedAt: time.Now()}) {
+		t.Fatalf("unable to create user %s", username)
+	}
+}
+
+func TestUniqueUsername(t *testing.T) {
+	store := database.NewMemoryStore()
+	if got := uniqueUsername(store, "alice"); got != "alice" {
+		t.Errorf("free username changed to %q", got)
+	}
+	createUser(t, store, "alice")
+	got := uniqueUsername(store, "alice")
+	if !strings.HasPrefix(got, "alice") || len(got) != len("alice")+usernameSuffixLength {
+		t.Errorf("taken username changed to %q, want a %d character suffix", got, usernameSuffixLength)
+	}
+	if got := uniqueUsername(store, ""); len(got) != usernameSuffixLength {
+		t.Errorf("empty username changed to %q", got)
+	}
+}
+
+func TestUniqueUsernameRunes(t *testing.T) {
+	store := database.NewMemoryStore()
+	long := strings.Repeat("月", maxUsernameLength+5)
+	got := uniqueUsername(store, long)
+	if !utf8.ValidString(got) || got != strings.Repeat("月", maxUsernameLength) {
+		t.Errorf("got %q, want the first %d characters", got, maxUsernameLength)
+	}
+	createUser(t, store, got)
+	got = uniqueUsername(store, long)
+	prefix := strings.Repeat("月", maxUsernameLength-usernameSuffixLength)
+	if !utf8.ValidString(got) || !strings.HasPrefix(got, prefix) || utf8.RuneCountInString(got) != maxUsernameLength {
+		t.Errorf("got %q, want %d characters ending in the suffix", got, maxUsernameLength)
+	}
+}
+
+func TestUniqueUsernameRetries(t *testing.T) {
+	// The name and the first two suffixes are taken
+	store := &takenStore{MemoryStore: database.NewMemoryStore(), taken: 3}
+	got := uniqueUsername(store, "alice")
+	if len(store.lookups) != 4 || got != store.lookups[3] {
+		t.Errorf("got %q after looking up %q, want the fourth name", got, store.lookups)
+	}
+	// Every name is taken
+	store = &takenStore{MemoryStore: database.NewMemoryStore(), taken: 100}
+	uniqueUsername(store, "alice")
+	if len(store.lookups) != 1+maxUsernameAttempts {
+		t.Errorf("looked up %d names, want %d", len(store.lookups), 1+maxUsernameAttempts)
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider is an OpenID Connect provider configured from the discovery
+// document of its issuer. Discovery happens on first use so an unreachable
+// provider doesn't keep the app from starting.
+type oidcProvider struct {
+	issuer string
+	config oauth2.Config
+	mu     sync.Mutex
+	oauth  *oauthProvider
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+func newOIDCProvider(issuer string, config oauth2.Config) *oidcProvider {
+	return &oidcProvider{issuer: strings.TrimSuffix(issuer, "/"), config: config}
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oauthProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, nil
+	}
+	var document discoveryDocument
+	if err := getJSON(ctx, httpClient, p.issuer+"/.well-known/openid-configuration", &document); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(document.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document of %s is for issuer %s", p.issuer, document.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("discovery document of %s is missing endpoints", p.issuer)
+	}
+	config := p.config
+	config.Endpoint = oauth2.Endpoint{
+		AuthURL:  document.AuthorizationEndpoint,
+		TokenURL: document.TokenEndpoint,
+	}
+	p.oauth = &oauthProvider{
+		config: config,
+		profile: func(ctx context.Context, client *http.Client) (*Profile, error) {
+			return userInfo(ctx, client, document.UserinfoEndpoint)
+		},
+	}
+	return p.oauth, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, verifier string, redirectURL string) (string, error) {
+	oauth, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(ctx, state, verifier, redirectURL)
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, verifier string, redirectURL string) (*Profile, error) {
+	oauth, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return oauth.Exchange(ctx, code, verifier, redirectURL)
+}
+
+// userInfo reads the standard claims of the user from the userinfo endpoint
+func userInfo(ctx context.Context, client *http.Client, endpoint string) (*Profile, error) {
+	var user models.OIDCUser
+	if err := getJSON(ctx, client, endpoint, &user); err != nil {
+		return nil, err
+	}
+	if user.Subject == "" {
+		return nil, fmt.Errorf("userinfo of %s has no subject", endpoint)
+	}
+	profile := &Profile{
+		Subject:  user.Subject,
+		Email:    user.Email,
+		Verified: user.EmailVerified,
+		Avatar:   user.Picture,
+	}
+	// Claims differ between providers, use the first one that is set
+	for _, username := range []string{user.PreferredUsername, user.GivenName, user.Name} {
+		if username != "" {
+			profile.Username = username
+			break
+		}
+	}
+	if profile.Username == "" && user.Email != nil {
+		profile.Username, _, _ = strings.Cut(*user.Email, "@")
+	}
+	return profile, nil
+}
+
+// getJSON decodes the response to a GET request, failing on error statuses
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
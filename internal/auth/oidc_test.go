@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// mockOIDC is a provider serving discovery, token and userinfo endpoints.
+// The discovery document is altered by the discovery function and the
+// userinfo endpoint returns claims.
+type mockOIDC struct {
+	*httptest.Server
+	discovery func(document map[string]string)
+	claims    map[string]any
+	// Form of the last token request
+	tokenRequest url.Values
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	mock := &mockOIDC{
+		discovery: func(map[string]string) {},
+		claims:    map[string]any{"sub": "1234"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		document := map[string]string{
+			"issuer":                 mock.URL,
+			"authorization_endpoint": mock.URL + "/authorize",
+			"token_endpoint":         mock.URL + "/token",
+			"userinfo_endpoint":      mock.URL + "/userinfo",
+		}
+		mock.discovery(document)
+		json.NewEncoder(w).Encode(document)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mock.tokenRequest = r.PostForm
+		if r.PostForm.Get("code") != "code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(mock.claims)
+	})
+	mock.Server = httptest.NewServer(mux)
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+func (m *mockOIDC) provider() *oidcProvider {
+	return newOIDCProvider(m.URL, oauth2.Config{
+		ClientID:     "tsuki",
+		ClientSecret: "secret",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	mock := newMockOIDC(t)
+	mock.discovery = func(document map[string]string) {
+		document["issuer"] = "https://attacker.example.com"
+	}
+	if _, err := mock.provider().AuthCodeURL(context.Background(), "state", "verifier", "http://localhost/auth/mock"); err == nil {
+		t.Fatal("discovery accepted a document of another issuer")
+	}
+}
+
+func TestOIDCDiscoveryRejectsMissingEndpoints(t *testing.T) {
+	for _, endpoint := range []string{"authorization_endpoint", "token_endpoint", "userinfo_endpoint"} {
+		t.Run(endpoint, func(t *testing.T) {
+			mock := newMockOIDC(t)
+			mock.discovery = func(document map[string]string) {
+				delete(document, endpoint)
+			}
+			if _, err := mock.provider().AuthCodeURL(context.Background(), "state", "verifier", "http://localhost/auth/mock"); err == nil {
+				t.Fatalf("discovery accepted a document without %s", endpoint)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeSendsVerifier(t *testing.T) {
+	mock := newMockOIDC(t)
+	mock.claims = map[string]any{
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+	}
+	provider := mock.provider()
+	ctx := context.Background()
+	consent, err := provider.AuthCodeURL(ctx, "state", "verifier", "http://localhost/auth/mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(consent)
+	query := parsed.Query()
+	if !strings.HasPrefix(consent, mock.URL+"/authorize?") || query.Get("state") != "state" {
+		t.Errorf("consent URL = %s", consent)
+	}
+	if query.Get("code_challenge") != codeChallenge("verifier") || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("consent URL has no S256 challenge: %s", consent)
+	}
+	profile, err := provider.Exchange(ctx, "code", "verifier", "http://localhost/auth/mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier := mock.tokenRequest.Get("code_verifier"); verifier != "verifier" {
+		t.Errorf("code_verifier = %q, want %q", verifier, "verifier")
+	}
+	if redirect := mock.tokenRequest.Get("redirect_uri"); redirect != "http://localhost/auth/mock" {
+		t.Errorf("redirect_uri = %q", redirect)
+	}
+	if profile.Subject != "1234" || profile.Username != "alice" || !profile.Verified ||
+		profile.Email == nil || *profile.Email != "alice@example.com" {
+		t.Errorf("profile = %+v", profile)
+	}
+}
+
+func TestOIDCUsernameFallback(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		claims   map[string]any
+		username string
+	}{
+		{"preferred username", map[string]any{"preferred_username": "alice", "given_name": "Alice", "name": "Alice Liddell"}, "alice"},
+		{"given name", map[string]any{"given_name": "Alice", "name": "Alice Liddell"}, "Alice"},
+		{"name", map[string]any{"name": "Alice Liddell", "email": "liddell@example.com"}, "Alice Liddell"},
+		{"email", map[string]any{"email": "liddell@example.com"}, "liddell"},
+		{"none", map[string]any{}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mock := newMockOIDC(t)
+			mock.claims = test.claims
+			mock.claims["sub"] = "1234"
+			profile, err := mock.provider().Exchange(context.Background(), "code", "verifier", "http://localhost/auth/mock")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.Username != test.username {
+				t.Errorf("username = %q, want %q", profile.Username, test.username)
+			}
+		})
+	}
+}
+
+func TestOIDCUserInfoRequiresSubject(t *testing.T) {
+	mock := newMockOIDC(t)
+	mock.claims = map[string]any{"preferred_username": "alice"}
+	if _, err := mock.provider().Exchange(context.Background(), "code", "verifier", "http://localhost/auth/mock"); err == nil {
+		t.Fatal("userinfo without a subject was accepted")
+	}
+}
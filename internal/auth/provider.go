@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Requests to providers give up after this long
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Profile is the account of a user at a login provider, Subject identifies
+// it and never changes
+type Profile struct {
+	Subject  string
+	Username string
+	Email    *string
+	Verified bool
+	Avatar   *string
+}
+
+// Provider is an OAuth 2.0 login provider
+type Provider interface {
+	// AuthCodeURL returns the consent page the user is sent to, with the
+	// PKCE challenge of the verifier
+	AuthCodeURL(ctx context.Context, state string, verifier string, redirectURL string) (string, error)
+	// Exchange trades the code of the callback for the profile of the user
+	Exchange(ctx context.Context, code string, verifier string, redirectURL string) (*Profile, error)
+}
+
+// oauthProvider fetches the profile from an API of the provider
+type oauthProvider struct {
+	config  oauth2.Config
+	profile func(ctx context.Context, client *http.Client) (*Profile, error)
+}
+
+func withClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
+
+// codeChallenge derives the S256 PKCE challenge sent with the consent page
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *oauthProvider) AuthCodeURL(ctx context.Context, state string, verifier string, redirectURL string) (string, error) {
+	config := p.config
+	config.RedirectURL = redirectURL
+	return config.AuthCodeURL(
+		state,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+func (p *oauthProvider) Exchange(ctx context.Context, code string, verifier string, redirectURL string) (*Profile, error) {
+	ctx = withClient(ctx)
+	config := p.config
+	config.RedirectURL = redirectURL
+	token, err := config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, err
+	}
+	return p.profile(ctx, config.Client(ctx, token))
+}
+
+var (
+	_ Provider = (*oauthProvider)(nil)
+	_ Provider = (*oidcProvider)(nil)
+)
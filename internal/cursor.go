@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func signCursor(payload string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// EncodeCursor returns an opaque token for the cursor, signed so that
+// clients cannot forge positions
+func EncodeCursor(cursor models.Cursor, key []byte) string {
+	data, _ := json.Marshal(cursor)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signCursor(payload, key)
+}
+
+func DecodeCursor(token string, key []byte) (*models.Cursor, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signCursor(payload, key))) {
+		return nil, errInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor models.Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// NextCursor returns the signed cursor for the page after items, or an
+// empty string if items was the last page
+func NextCursor[T interface{ Cursor() models.Cursor }](items []T, limit int, key []byte) string {
+	if len(items) < limit {
+		return ""
+	}
+	return EncodeCursor(items[len(items)-1].Cursor(), key)
+}
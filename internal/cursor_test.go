@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+var cursorKey = []byte("cursor key")
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := models.Cursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC), Id: "post"}
+	decoded, err := DecodeCursor(EncodeCursor(cursor, cursorKey), cursorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.Id != cursor.Id {
+		t.Errorf("decoded %+v, want %+v", decoded, cursor)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	cursor := models.Cursor{CreatedAt: time.Now(), Id: "post"}
+	token := EncodeCursor(cursor, cursorKey)
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"CreatedAt":"2999-01-01T00:00:00Z","Id":"post"}`))
+	// A signed payload that isn't a cursor
+	notJSON := base64.RawURLEncoding.EncodeToString([]byte("not json"))
+	for name, token := range map[string]string{
+		"empty":             "",
+		"no signature":      payload,
+		"empty signature":   payload + ".",
+		"truncated":         token[:len(token)-4],
+		"truncated payload": payload[1:] + "." + signature,
+		"tampered payload":  forged + "." + signature,
+		"tampered":          payload + "." + strings.Repeat("A", len(signature)),
+		"foreign key":       EncodeCursor(cursor, []byte("other key")),
+		"not json":          notJSON + "." + signCursor(notJSON, cursorKey),
+		"not base64":        "!!!." + signCursor("!!!", cursorKey),
+	} {
+		if decoded, err := DecodeCursor(token, cursorKey); err == nil {
+			t.Errorf("%s: decoded %+v", name, decoded)
+		}
+	}
+}
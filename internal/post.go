@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// Posts can be edited for this long by default
+const defaultPostEditWindow = 15 * time.Minute
+
+// PostEditWindow returns how long after posting authors can edit a post,
+// read from POST_EDIT_WINDOW as a duration such as "1h". Zero turns editing
+// off.
+func PostEditWindow() time.Duration {
+	window, err := time.ParseDuration(os.Getenv("POST_EDIT_WINDOW"))
+	if err != nil || window < 0 {
+		return defaultPostEditWindow
+	}
+	return window
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"math/rand"
+	"net"
 	"strings"
 	"time"
 
@@ -28,3 +29,18 @@ func FormatAsTitle(title string) string {
 func FormatAsDate(createdAt time.Time) string {
 	return createdAt.Format(time.RFC822)
 }
+
+// FormatAsNetwork hides the host part of an IP address, the network is
+// enough to tell devices apart without showing the exact address
+func FormatAsNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "Unknown"
+	}
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		network := net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+		return network.String()
+	}
+	network := net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}
+	return network.String()
+}
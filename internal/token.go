@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewToken returns a random secret with the given prefix, used for tokens
+// that are handed to the user once and stored hashed
+func NewToken(prefix string) string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// HashToken returns the hex encoded SHA-256 of a token, random tokens have
+// enough entropy to not need a slow password hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of RFC 6238 supported by every authenticator app
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Codes of the previous and next period are accepted to allow for
+	// clock drift
+	totpSkew = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160 bit secret in base32, the length
+// recommended by RFC 4226
+func NewTOTPSecret() string {
+	data := make([]byte, 20)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return secretEncoding.EncodeToString(data)
+}
+
+// TOTPURI returns the otpauth URI shown as a QR code during enrollment
+func TOTPURI(secret string, issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	// Dynamic truncation of RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// VerifyTOTP checks a code against the secret and returns the time step it
+// belongs to, callers reject steps that were already used to stop replays
+func VerifyTOTP(secret string, code string, now time.Time) (int64, bool) {
+	key, err := secretEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// NewRecoveryCodes returns single-use codes of 80 random bits formatted as
+// xxxx-xxxx-xxxx-xxxx, enough entropy to store them with HashToken
+func NewRecoveryCodes(count int) []string {
+	codes := make([]string, count)
+	for index := range codes {
+		data := make([]byte, 10)
+		if _, err := rand.Read(data); err != nil {
+			panic(err)
+		}
+		code := strings.ToLower(secretEncoding.EncodeToString(data))
+		codes[index] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+	}
+	return codes
+}
+
+// NormalizeRecoveryCode lets users type recovery codes without dashes or in
+// upper case
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 16 {
+		return code
+	}
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
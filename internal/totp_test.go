@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238 appendix B. The RFC lists 8 digit
+// codes, a 6 digit code is the same value modulo 10^6.
+var rfc6238Vectors = []struct {
+	time int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+var rfc6238Secret = secretEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, vector := range rfc6238Vectors {
+		if code := totpCode(key, vector.time/totpPeriod); code != vector.code {
+			t.Errorf("code at %d = %s, want %s", vector.time, code, vector.code)
+		}
+		step, ok := VerifyTOTP(rfc6238Secret, vector.code, time.Unix(vector.time, 0))
+		if !ok || step != vector.time/totpPeriod {
+			t.Errorf("VerifyTOTP at %d = %d, %v", vector.time, step, ok)
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	// 081804 belongs to step 37037036, from 1111111080 to 1111111109
+	code := "081804"
+	const step = 37037036
+	for _, test := range []struct {
+		time int64
+		ok   bool
+	}{
+		{step*totpPeriod - totpPeriod - 1, false},
+		{step*totpPeriod - totpPeriod, true},
+		{step * totpPeriod, true},
+		{step*totpPeriod + 2*totpPeriod - 1, true},
+		{step*totpPeriod + 2*totpPeriod, false},
+	} {
+		verified, ok := VerifyTOTP(rfc6238Secret, code, time.Unix(test.time, 0))
+		if ok != test.ok || (ok && verified != step) {
+			t.Errorf("VerifyTOTP at %d = %d, %v, want ok %v", test.time, verified, ok, test.ok)
+		}
+	}
+	// Spaces are ignored, other lengths rejected
+	if _, ok := VerifyTOTP(rfc6238Secret, "287 082", time.Unix(59, 0)); !ok {
+		t.Error("code with a space was rejected")
+	}
+	if _, ok := VerifyTOTP(rfc6238Secret, "94287082", time.Unix(59, 0)); ok {
+		t.Error("8 digit code was accepted")
+	}
+	if _, ok := VerifyTOTP("not base32!", code, time.Unix(59, 0)); ok {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"abcd-efgh-ijkl-mnop", "ABCDEFGHIJKLMNOP", "abcd efgh ijkl mnop"} {
+		if normalized := NormalizeRecoveryCode(code); normalized != "abcd-efgh-ijkl-mnop" {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", code, normalized)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"os"
+	"strings"
+)
+
+// BaseURL returns the public address of the app from BASE_URL, used for
+// links in mails and OAuth redirect URIs
+func BaseURL() string {
+	baseURL := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		return "http://localhost:8080"
+	}
+	return baseURL
+}
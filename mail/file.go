@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes every mail to a directory as an .eml file, or to an
+// io.Writer such as stdout, for local development
+type FileMailer struct {
+	from string
+	dir  string
+	mu   sync.Mutex
+	out  io.Writer
+}
+
+func NewFileMailer(from, dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{from: from, dir: dir}, nil
+}
+
+func NewWriterMailer(from string, out io.Writer) *FileMailer {
+	return &FileMailer{from: from, out: out}
+}
+
+func (m *FileMailer) Send(message *Message) error {
+	byteMessage, err := encode(m.from, message).Bytes()
+	if err != nil {
+		return err
+	}
+	if m.out != nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		_, err := fmt.Fprintf(m.out, "%s\n\n", byteMessage)
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(m.dir, name), byteMessage, 0o644)
+}
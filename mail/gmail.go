@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+type GmailMailer struct {
+	from    string
+	service *gmail.Service
+}
+
+// The client refreshes the access token itself whenever it expires, the
+// refreshed token only lives in memory
+func NewGmailMailer(from string, config *oauth2.Config, token *oauth2.Token) (*GmailMailer, error) {
+	ctx := context.Background()
+	client := config.Client(ctx, token)
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &GmailMailer{from: from, service: service}, nil
+}
+
+// Build the Gmail API configuration from the OAuth credentials of the app
+func GmailConfig(clientId, clientSecret, tokenURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientId,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"https://mail.google.com/"},
+		Endpoint: oauth2.Endpoint{
+			TokenURL: tokenURI,
+		},
+	}
+}
+
+func GmailToken(accessToken, refreshToken, expiry string) *oauth2.Token {
+	parsed, _ := time.Parse(time.RFC3339, expiry)
+	return &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       parsed,
+	}
+}
+
+func (m *GmailMailer) Send(message *Message) error {
+	byteMessage, err := encode(m.from, message).Bytes()
+	if err != nil {
+		return err
+	}
+	mailContent := gmail.Message{
+		Raw: base64.RawURLEncoding.EncodeToString(byteMessage),
+	}
+	_, err = m.service.Users.Messages.Send("me", &mailContent).Do()
+	return err
+}
@@ -0,0 +1,32 @@
+package mail
+
+import "github.com/jordan-wright/email"
+
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Mailer delivers messages through one of the configured backends
+type Mailer interface {
+	Send(message *Message) error
+}
+
+// Encode the message as an RFC 5322 mail
+func encode(from string, message *Message) *email.Email {
+	return &email.Email{
+		To:      []string{message.To},
+		From:    from,
+		Subject: message.Subject,
+		HTML:    []byte(message.HTML),
+		Text:    []byte(message.Text),
+	}
+}
+
+var (
+	_ Mailer = (*SMTPMailer)(nil)
+	_ Mailer = (*GmailMailer)(nil)
+	_ Mailer = (*FileMailer)(nil)
+)
@@ -0,0 +1,96 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// Mails failing this many times are moved to the dead letters
+	MaxAttempts  = 8
+	pollInterval = 5 * time.Second
+	claimLease   = time.Minute
+	batchSize    = 20
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+)
+
+// Queue a message in the outbox. A pending message with the same dedup key
+// is replaced instead, an empty key never deduplicates.
+func Enqueue(store database.OutboxStore, message *Message, dedupKey string) bool {
+	now := time.Now()
+	mail := models.Mail{
+		Id:            uuid.NewString(),
+		Recipient:     message.To,
+		Subject:       message.Subject,
+		HTML:          message.HTML,
+		Text:          message.Text,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if dedupKey != "" {
+		mail.DedupKey = &dedupKey
+	}
+	return store.EnqueueMail(&mail)
+}
+
+// Outbox delivers queued mails in the background
+type Outbox struct {
+	store  database.OutboxStore
+	mailer Mailer
+}
+
+func NewOutbox(store database.OutboxStore, mailer Mailer) *Outbox {
+	return &Outbox{store: store, mailer: mailer}
+}
+
+// Run delivers due mails until the context is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		o.deliver(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Outbox) deliver(now time.Time) {
+	for _, mail := range o.store.ClaimMail(now, claimLease, batchSize) {
+		err := o.mailer.Send(&Message{
+			To:      mail.Recipient,
+			Subject: mail.Subject,
+			HTML:    mail.HTML,
+			Text:    mail.Text,
+		})
+		if err == nil {
+			o.store.MarkMailSent(mail.Id, mail.Version, time.Now())
+			continue
+		}
+		dead := mail.Attempts >= MaxAttempts
+		log.Printf("mail %s to %s failed (attempt %d): %v", mail.Id, mail.Recipient, mail.Attempts, err)
+		o.store.MarkMailFailed(mail.Id, mail.Version, err.Error(), time.Now().Add(backoff(mail.Attempts)), dead)
+	}
+}
+
+// backoff doubles the delay after every attempt, with some jitter so
+// mails failing together are not retried together
+func backoff(attempts int) time.Duration {
+	delay := maxBackoff
+	if attempts < 20 {
+		delay = baseBackoff << (attempts - 1)
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/10)+1))
+}
@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPMailer sends mail through a plain SMTP server, upgrading to TLS when
+// the server offers STARTTLS
+type SMTPMailer struct {
+	from string
+	addr string
+	auth smtp.Auth
+}
+
+// Credentials are optional, a server without authentication is used as is
+func NewSMTPMailer(from, host, port, username, password string) *SMTPMailer {
+	mailer := &SMTPMailer{
+		from: from,
+		addr: net.JoinHostPort(host, port),
+	}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (m *SMTPMailer) Send(message *Message) error {
+	return encode(m.from, message).Send(m.addr, m.auth)
+}
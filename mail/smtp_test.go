@@ -0,0 +1,225 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// envelope is what the fake server received in one session
+type envelope struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP accepts a single session on a local port, offering AUTH PLAIN if
+// auth is set, and sends what it received on the returned channel
+func fakeSMTP(t *testing.T, auth bool) (string, <-chan envelope) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan envelope, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var session envelope
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case command == "EHLO" || command == "HELO":
+				if auth {
+					reply("250-localhost")
+					reply("250 AUTH PLAIN")
+				} else {
+					reply("250 localhost")
+				}
+			case command == "AUTH":
+				fields := strings.Fields(line)
+				decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+				session.auth = string(decoded)
+				reply("235 2.7.0 Authentication successful")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				session.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+				reply("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				session.to = append(session.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(line, "."))
+				}
+				session.data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				received <- session
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+// parts returns the bodies of the mail by content type, walking nested
+// multipart bodies
+func parts(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]string)
+	var walk func(contentType, encoding string, body io.Reader)
+	walk = func(contentType, encoding string, body io.Reader) {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			// Parts are decoded by the multipart reader, a single body isn't
+			if strings.EqualFold(encoding, "quoted-printable") {
+				body = quotedprintable.NewReader(body)
+			}
+			content, _ := io.ReadAll(body)
+			found[mediaType] = string(content)
+			return
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			walk(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+		}
+	}
+	walk(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	return found
+}
+
+func checkMessage(t *testing.T, data []byte) {
+	t.Helper()
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := message.Header.Get("Subject"); subject != "Verify your account" {
+		t.Errorf("subject = %q", subject)
+	}
+	if to, err := message.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Address != "alice@example.com" {
+		t.Errorf("To = %q", message.Header.Get("To"))
+	}
+	found := parts(t, data)
+	if !strings.Contains(found["text/html"], "<a href=\"https://tsuki.test/verify\">") {
+		t.Errorf("HTML part = %q", found["text/html"])
+	}
+	if !strings.Contains(found["text/plain"], "https://tsuki.test/verify") {
+		t.Errorf("text part = %q", found["text/plain"])
+	}
+}
+
+var testMessage = &Message{
+	To:      "alice@example.com",
+	Subject: "Verify your account",
+	HTML:    `<p>Verify your account: <a href="https://tsuki.test/verify">verify</a></p>`,
+	Text:    "Verify your account: https://tsuki.test/verify",
+}
+
+func TestSMTPMailer(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		username string
+		auth     string
+	}{
+		{"without auth", "", ""},
+		{"with auth", "tsuki", "\x00tsuki\x00secret"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr, received := fakeSMTP(t, test.username != "")
+			host, port, _ := net.SplitHostPort(addr)
+			mailer := NewSMTPMailer("tsuki@example.com", host, port, test.username, "secret")
+			if err := mailer.Send(testMessage); err != nil {
+				t.Fatal(err)
+			}
+			session := <-received
+			if session.auth != test.auth {
+				t.Errorf("auth = %q, want %q", session.auth, test.auth)
+			}
+			if session.from != "tsuki@example.com" {
+				t.Errorf("MAIL FROM = %q", session.from)
+			}
+			if len(session.to) != 1 || session.to[0] != "alice@example.com" {
+				t.Errorf("RCPT TO = %q", session.to)
+			}
+			checkMessage(t, []byte(session.data))
+		})
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewFileMailer("tsuki@example.com", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("wrote %d files, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, data)
+}
+
+func TestWriterMailer(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewWriterMailer("tsuki@example.com", &out).Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, out.Bytes())
+}
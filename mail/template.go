@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+const TemplatesKey = "mailTemplates"
+
+// Mails are rendered in this language when no other one matches
+var defaultLocale = language.English
+
+type localized struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// Templates holds the mail templates of every locale. A directory holds the
+// shared layout.html and a directory per locale, with a name.html and a
+// name.txt for every mail. The text template defines the subject as well.
+type Templates struct {
+	locales []language.Tag
+	matcher language.Matcher
+	mails   map[language.Tag]map[string]localized
+}
+
+func LoadTemplates(dir string) (*Templates, error) {
+	layout := filepath.Join(dir, "layout.html")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	templates := &Templates{mails: make(map[language.Tag]map[string]localized)}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale, err := language.Parse(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("mail templates: %w", err)
+		}
+		files, err := filepath.Glob(filepath.Join(dir, entry.Name(), "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		mails := make(map[string]localized)
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".html")
+			html, err := htmltemplate.ParseFiles(layout, file)
+			if err != nil {
+				return nil, err
+			}
+			text, err := texttemplate.ParseFiles(strings.TrimSuffix(file, ".html") + ".txt")
+			if err != nil {
+				return nil, err
+			}
+			if text.Lookup("subject") == nil {
+				return nil, fmt.Errorf("mail templates: %s has no subject", file)
+			}
+			mails[name] = localized{html: html, text: text}
+		}
+		templates.mails[locale] = mails
+		// The default locale goes first so the matcher falls back to it
+		if locale == defaultLocale {
+			templates.locales = append([]language.Tag{locale}, templates.locales...)
+		} else {
+			templates.locales = append(templates.locales, locale)
+		}
+	}
+	if _, ok := templates.mails[defaultLocale]; !ok {
+		return nil, fmt.Errorf("mail templates: no %s templates in %s", defaultLocale, dir)
+	}
+	templates.matcher = language.NewMatcher(templates.locales)
+	return templates, nil
+}
+
+// Render the mail in the locale best matching an Accept-Language header
+func (t *Templates) Render(name string, acceptLanguage string, to string, data any) (*Message, error) {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, _ := t.matcher.Match(tags...)
+	mail, ok := t.mails[t.locales[index]][name]
+	if !ok {
+		if mail, ok = t.mails[defaultLocale][name]; !ok {
+			return nil, fmt.Errorf("mail templates: no template %s", name)
+		}
+	}
+	var subject, text, html bytes.Buffer
+	if err := mail.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := mail.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := mail.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, err
+	}
+	return &Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// Return the templates set by the mail templates middleware
+func Default(c *gin.Context) *Templates {
+	return c.MustGet(TemplatesKey).(*Templates)
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/Devansh3712/tsuki-go/api"
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/docs"
 	"github.com/Devansh3712/tsuki-go/internal"
 	socials "github.com/Devansh3712/tsuki-go/internal/auth"
+	"github.com/Devansh3712/tsuki-go/mail"
+	"github.com/Devansh3712/tsuki-go/media"
 	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/Devansh3712/tsuki-go/routes"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -20,73 +31,218 @@ func index(c *gin.Context) {
 }
 
 func notFound(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		api.NotFound(c)
+		return
+	}
 	c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 		"error":   "404 Not Found",
 		"message": "The requested page was not found.",
 	})
 }
 
-func main() {
-	godotenv.Load(".env")
-	gin.SetMode(gin.ReleaseMode)
+func newStore() (database.Store, error) {
+	// In-memory store for local development, data is lost on restart
+	if os.Getenv("STORE") == "memory" {
+		log.Println("Using in-memory store")
+		return database.NewMemoryStore(), nil
+	}
+	store, err := database.NewPostgresStore(os.Getenv("POSTGRES_URI"))
+	if err != nil {
+		return nil, err
+	}
+	// Instances booting together wait on the migration lock
+	if _, err := store.MigrateUp(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func newMailer() (mail.Mailer, error) {
+	from := os.Getenv("EMAIL")
+	switch backend := os.Getenv("MAILER"); backend {
+	case "", "gmail":
+		config := mail.GmailConfig(os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"), os.Getenv("TOKEN_URI"))
+		token := mail.GmailToken(os.Getenv("TOKEN"), os.Getenv("REFRESH_TOKEN"), os.Getenv("EXPIRY"))
+		return mail.NewGmailMailer(from, config, token)
+	case "smtp":
+		return mail.NewSMTPMailer(
+			from,
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		), nil
+	case "file":
+		return mail.NewFileMailer(from, os.Getenv("MAIL_DIR"))
+	// Print mails instead of sending them, for local development
+	case "log":
+		log.Println("Printing mails to stdout")
+		return mail.NewWriterMailer(from, os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", backend)
+	}
+}
 
+func newBlobStore() (media.BlobStore, error) {
+	switch backend := os.Getenv("MEDIA_STORE"); backend {
+	case "", "freeimage":
+		return media.NewFreeimageStore(os.Getenv("FREEIMAGE_API_KEY")), nil
+	case "local":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return media.NewLocalStore(dir, internal.BaseURL()+"/media"), nil
+	case "s3":
+		return media.NewS3Store(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_REGION"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY_ID"),
+			os.Getenv("S3_SECRET_ACCESS_KEY"),
+			os.Getenv("S3_PUBLIC_URL"),
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown media store %q", backend)
+	}
+}
+
+// newRateLimits keeps the rate limits in memory unless instances share
+// them through PostgreSQL
+func newRateLimits(db database.Store) (database.RateLimitStore, error) {
+	switch backend := os.Getenv("RATE_LIMIT_STORE"); backend {
+	case "", "memory":
+		return database.NewMemoryRateLimitStore(), nil
+	case "postgres":
+		store, ok := db.(*database.PostgresStore)
+		if !ok {
+			return nil, errors.New("postgres rate limits need the PostgreSQL store")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", backend)
+	}
+}
+
+func newRouter(db database.Store, limits database.RateLimitStore, blobs media.BlobStore) *gin.Engine {
 	app := gin.Default()
+	// Rate limits are keyed by the client address, only trust the
+	// forwarding headers of known proxies when they are listed
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := app.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			panic(err)
+		}
+	}
 	app.RedirectTrailingSlash = true
 	app.HandleMethodNotAllowed = true
 	app.NoRoute(notFound)
 
 	app.Static("/static", "./static")
+	// Uploads kept on disk are served by the app itself
+	if local, ok := blobs.(*media.LocalStore); ok {
+		app.Static("/media", local.Dir())
+	}
 	app.SetFuncMap(template.FuncMap{
-		"formatAsTitle": internal.FormatAsTitle,
-		"formatAsDate":  internal.FormatAsDate,
+		"formatAsTitle":   internal.FormatAsTitle,
+		"formatAsDate":    internal.FormatAsDate,
+		"formatAsNetwork": internal.FormatAsNetwork,
 	})
-	app.LoadHTMLGlob("templates/*")
+	app.LoadHTMLGlob("templates/*.tmpl.html")
+	mails, err := mail.LoadTemplates("templates/mail")
+	if err != nil {
+		panic(err)
+	}
 	store := cookie.NewStore([]byte(os.Getenv("SECRET_KEY")))
 	app.Use(sessions.Sessions("tsuki", store))
 	app.Use(middleware.RecoveryMiddleware())
+	app.Use(middleware.StoreMiddleware(db))
+	// The token endpoints take credentials in the body, not the cookie
+	app.Use(middleware.CSRFMiddleware("/api/v1/auth/token", "/api/v1/auth/refresh", "/api/v1/auth/revoke"))
+	app.Use(middleware.RateLimitStoreMiddleware(limits))
+	app.Use(middleware.MailMiddleware(mails))
+	app.Use(middleware.MediaMiddleware(blobs))
 
 	app.GET("/", index)
 	app.GET("/signup", routes.SignUp)
 	app.GET("/login", routes.Login)
 	app.GET("/logout", routes.Logout)
-	app.GET("/feed", middleware.AuthMiddleware(), routes.UserFeed)
-	app.GET("/feed/more", middleware.AuthMiddleware(), routes.LoadMoreFeed)
+	app.POST("/logout", routes.Logout)
+	// Personal access tokens are only accepted by routes listing a scope
+	read := middleware.AuthMiddleware(models.ScopeRead)
+	writePosts := middleware.AuthMiddleware(models.ScopeWritePosts)
+	writeFollows := middleware.AuthMiddleware(models.ScopeWriteFollows)
+	// Throttle guessing passwords and codes, and mass signups
+	loginByIP := middleware.RateLimitMiddleware("login-ip", middleware.LoginIPLimit, middleware.ClientIP)
+	loginByUsername := middleware.RateLimitMiddleware("login-user", middleware.LoginUsernameLimit, middleware.FormValue("username"))
+	twoFactorByUser := middleware.RateLimitMiddleware("2fa-user", middleware.TwoFactorLimit, middleware.PendingUserId)
+	signUpByIP := middleware.RateLimitMiddleware("signup-ip", middleware.SignUpIPLimit, middleware.ClientIP)
+	forgotByIP := middleware.RateLimitMiddleware("forgot-ip", middleware.ForgotIPLimit, middleware.ClientIP)
+	forgotByEmail := middleware.RateLimitMiddleware("forgot-email", middleware.ForgotEmailLimit, middleware.FormValue("email"))
+
+	app.GET("/feed", read, routes.UserFeed)
+	app.GET("/feed/more", read, routes.LoadMoreFeed)
 
 	auth := app.Group("/auth")
 	{
-		auth.GET("/signup/discord", socials.DiscordSignUp)
-		auth.GET("/signup/github", socials.GitHubSignUp)
-		auth.GET("/signup/google", socials.GoogleSignUp)
-		auth.GET("/login/discord", socials.DiscordLogin)
-		auth.GET("/login/github", socials.GitHubLogin)
-		auth.GET("/login/google", socials.GoogleLogin)
-		auth.GET("/discord", socials.DiscordAuth)
-		auth.GET("/github", socials.GitHubAuth)
-		auth.GET("/google", socials.GoogleAuth)
+		auth.GET("/signup/:provider", socials.SignUp)
+		auth.GET("/login/:provider", socials.Login)
+		auth.GET("/:provider", socials.Callback)
 		auth.GET("/verify", middleware.AuthMiddleware(), routes.SendVerificationMail)
 		auth.GET("/verify/:id", routes.Verify)
+		auth.GET("/forgot", routes.ForgotPassword)
+		auth.GET("/reset/:id", routes.ResetPassword)
+		auth.GET("/email/:id", routes.ConfirmEmail)
+		auth.GET("/email/undo/:id", routes.UndoEmail)
 
-		auth.POST("/signup", routes.SignUp)
-		auth.POST("/login", routes.Login)
+		auth.POST("/signup", signUpByIP, routes.SignUp)
+		auth.POST("/login", loginByIP, loginByUsername, routes.Login)
+		auth.POST("/verify", middleware.AuthMiddleware(), routes.SendVerificationMail)
+		auth.POST("/forgot", forgotByIP, forgotByEmail, routes.ForgotPassword)
+		auth.POST("/reset/:id", routes.ResetPassword)
+		auth.GET("/login/2fa", routes.LoginTwoFactor)
+		auth.POST("/login/2fa", loginByIP, twoFactorByUser, routes.LoginTwoFactor)
+		auth.POST("/passkey/begin", routes.BeginPasskeyLogin)
+		auth.POST("/passkey/finish", routes.FinishPasskeyLogin)
 	}
 
 	user := app.Group("/user")
 	user.GET("/:username", routes.GetUserByName)
 	user.GET("/:username/posts", routes.GetUserPosts)
 	user.GET("/:username/posts/more", routes.LoadMorePosts)
+	user.GET("/", read, routes.GetUser)
+	user.POST("/:username/toggle-follow", writeFollows, routes.ToggleFollow)
 	user.Use(middleware.AuthMiddleware())
 	{
-		user.GET("/", routes.GetUser)
 		user.GET("/settings/avatar", routes.UpdateAvatar)
 		user.GET("/settings/username", routes.UpdateUsername)
+		user.GET("/settings/email", routes.UpdateEmail)
 		user.GET("/settings/password", routes.UpdatePassword)
 		user.GET("/settings/delete", routes.DeleteUser)
+		user.GET("/settings/tokens", routes.AccessTokens)
+		user.GET("/settings/sessions", routes.Sessions)
+		user.GET("/settings/2fa", routes.TwoFactor)
+		user.GET("/settings/passkeys", routes.Passkeys)
+		user.GET("/settings/identities", routes.Identities)
 
-		user.POST("/:username/toggle-follow", routes.ToggleFollow)
 		user.POST("/settings/avatar", routes.UpdateAvatar)
 		user.POST("/settings/username", routes.UpdateUsername)
+		user.POST("/settings/email", routes.UpdateEmail)
 		user.POST("/settings/password", routes.UpdatePassword)
 		user.POST("/settings/delete", routes.DeleteUser)
+		user.POST("/settings/tokens", routes.AccessTokens)
+		user.POST("/settings/tokens/:id/revoke", routes.RevokeAccessToken)
+		user.POST("/settings/sessions/:id/revoke", routes.RevokeSession)
+		user.POST("/settings/sessions/revoke-others", routes.RevokeOtherSessions)
+		user.POST("/settings/logout", routes.LogoutEverywhere)
+		user.POST("/settings/2fa", routes.TwoFactor)
+		user.POST("/settings/2fa/recovery-codes", routes.RegenerateRecoveryCodes)
+		user.POST("/settings/2fa/disable", routes.DisableTwoFactor)
+		user.POST("/settings/passkeys/begin", routes.BeginPasskeyRegistration)
+		user.POST("/settings/passkeys/finish", routes.FinishPasskeyRegistration)
+		user.POST("/settings/passkeys/:id/delete", routes.DeletePasskey)
+		user.POST("/settings/identities/:provider/link", socials.Link)
+		user.POST("/settings/identities/:provider/unlink", routes.UnlinkIdentity)
 	}
 
 	search := app.Group("/search")
@@ -95,23 +251,99 @@ func main() {
 		search.GET("/more", routes.LoadMoreUsers)
 
 		search.POST("/", routes.SearchUser)
-		search.POST("/:username/toggle-follow", middleware.AuthMiddleware(), routes.ToggleSearchFollow)
+		search.POST("/:username/toggle-follow", writeFollows, routes.ToggleSearchFollow)
 	}
 
 	post := app.Group("/post")
 	post.GET("/:id", routes.GetPost)
-	post.Use(middleware.AuthMiddleware())
 	{
-		post.GET("/", routes.NewPost)
-		post.GET("/:id/toggle-vote", routes.ToggleVote)
-		post.GET("/:id/delete", routes.DeletePost)
-		post.GET("/:id/comments", routes.LoadMoreComments)
-		post.GET("/:id/comment/delete", routes.DeleteComment)
+		post.GET("/", middleware.AuthMiddleware(), routes.NewPost)
+		post.GET("/:id/comments", read, routes.LoadMoreComments)
+		post.GET("/:id/edit", middleware.AuthMiddleware(), routes.EditPost)
+
+		post.POST("/", writePosts, routes.NewPost)
+		post.POST("/:id/toggle-vote", writePosts, routes.ToggleVote)
+		post.POST("/:id/edit", writePosts, routes.EditPost)
+		post.POST("/:id/delete", writePosts, routes.DeletePost)
+		post.POST("/:id/comment", writePosts, routes.Comment)
+		post.POST("/:id/comment/delete", writePosts, routes.DeleteComment)
+	}
+
+	app.GET("/api/openapi.json", docs.Serve)
+	v1 := app.Group("/api/v1")
+	{
+		v1.POST("/auth/token", api.CreateToken)
+		v1.POST("/auth/refresh", api.RefreshToken)
+		v1.POST("/auth/revoke", api.RevokeToken)
+
+		public := v1.Group("/", api.OptionalAuthMiddleware())
+		public.GET("/users", api.SearchUsers)
+		public.GET("/users/:username", api.GetUser)
+		public.GET("/users/:username/posts", api.GetUserPosts)
+		public.GET("/users/:username/followers", api.GetFollowers)
+		public.GET("/users/:username/following", api.GetFollowing)
+		public.GET("/posts/:id", api.GetPost)
+		public.GET("/posts/:id/revisions", api.GetRevisions)
+		public.GET("/posts/:id/votes", api.GetVotes)
+		public.GET("/posts/:id/comments", api.GetComments)
 
-		post.POST("/", routes.NewPost)
-		post.POST("/:id/comment", routes.Comment)
+		apiRead := api.AuthMiddleware(models.ScopeRead)
+		apiWritePosts := api.AuthMiddleware(models.ScopeWritePosts)
+		apiWriteFollows := api.AuthMiddleware(models.ScopeWriteFollows)
+		v1.GET("/user", apiRead, api.GetCurrentUser)
+		v1.GET("/feed", apiRead, api.GetFeed)
+		v1.PUT("/users/:username/follow", apiWriteFollows, api.Follow)
+		v1.DELETE("/users/:username/follow", apiWriteFollows, api.Unfollow)
+		v1.POST("/posts", apiWritePosts, api.CreatePost)
+		v1.PATCH("/posts/:id", apiWritePosts, api.EditPost)
+		v1.DELETE("/posts/:id", apiWritePosts, api.DeletePost)
+		v1.PUT("/posts/:id/vote", apiWritePosts, api.Vote)
+		v1.DELETE("/posts/:id/vote", apiWritePosts, api.Unvote)
+		v1.POST("/posts/:id/comments", apiWritePosts, api.CreateComment)
+		v1.DELETE("/posts/:id/comments/:commentId", apiWritePosts, api.DeleteComment)
 	}
 
+	return app
+}
+
+func main() {
+	godotenv.Load(".env")
+	if len(os.Args) > 1 {
+		commands := map[string]func(args []string) error{
+			"migrate": migrate,
+			"openapi": openapi,
+			"outbox":  outbox,
+		}
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	gin.SetMode(gin.ReleaseMode)
+
+	db, err := newStore()
+	if err != nil {
+		panic(err)
+	}
+	mailer, err := newMailer()
+	if err != nil {
+		panic(err)
+	}
+	// Mails queued by the handlers are delivered in the background
+	go mail.NewOutbox(db, mailer).Run(context.Background())
+	limits, err := newRateLimits(db)
+	if err != nil {
+		panic(err)
+	}
+	go middleware.PruneRateLimits(context.Background(), limits)
+	blobs, err := newBlobStore()
+	if err != nil {
+		panic(err)
+	}
+	app := newRouter(db, limits, blobs)
 	if err := app.Run(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,122 @@
+package media
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Key under which the blob store is saved in the gin context
+const StoreKey = "blobStore"
+
+// Uploads are sent to remote backends with this timeout
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// BlobStore keeps uploaded files through one of the configured backends.
+// Put returns the URL the file is served from.
+type BlobStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Images accepted for upload and the extension of their keys, other types
+// are never stored so they can't be served as pages
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Key returns the content-addressed key of a file, the same upload always
+// gets the same key. It reports false for types that can't be uploaded.
+func Key(prefix string, contentType string, data []byte) (string, bool) {
+	extension, ok := extensions[contentType]
+	if !ok {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return prefix + "/" + hex.EncodeToString(sum[:]) + extension, true
+}
+
+// KeyOf returns the key of a blob from the URL it is served from. URLs the
+// backend picked itself, like those of Freeimage or of OAuth providers,
+// don't end in a key and report false.
+func KeyOf(prefix string, url string) (string, bool) {
+	index := strings.LastIndex(url, "/"+prefix+"/")
+	if index < 0 {
+		return "", false
+	}
+	key := url[index+1:]
+	name := strings.TrimPrefix(key, prefix+"/")
+	for _, extension := range extensions {
+		sum, ok := strings.CutSuffix(name, extension)
+		if !ok || len(sum) != 2*sha256.Size {
+			continue
+		}
+		if _, err := hex.DecodeString(sum); err == nil {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Return the blob store set by the media middleware
+func Default(c *gin.Context) BlobStore {
+	return c.MustGet(StoreKey).(BlobStore)
+}
+
+var (
+	_ BlobStore = (*LocalStore)(nil)
+	_ BlobStore = (*S3Store)(nil)
+	_ BlobStore = (*FreeimageStore)(nil)
+)
+
+// Attachments tells which blobs posts attach. LockMedia holds the keys
+// until unlock is called, so a blob can't be deleted between the check and
+// a new post attaching it.
+type Attachments interface {
+	MediaInUse(key string) bool
+	LockMedia(keys []string) (unlock func(), ok bool)
+}
+
+// Keys returns the blob keys of the media
+func Keys(attachments []models.Media) []string {
+	keys := make([]string, len(attachments))
+	for i, attachment := range attachments {
+		keys[i] = attachment.Key
+	}
+	return keys
+}
+
+// DeleteUnused removes the blobs of the post media no post attaches, posts
+// attaching the same image share its blob. The keys stay locked from the
+// check until the delete, requests creating posts lock them from the
+// upload until the post is saved.
+func DeleteUnused(c *gin.Context, attachments []models.Media, posts Attachments) {
+	if len(attachments) == 0 {
+		return
+	}
+	unlock, ok := posts.LockMedia(Keys(attachments))
+	if !ok {
+		// Keep the blobs if unsure
+		return
+	}
+	defer unlock()
+	blobs := Default(c)
+	for _, attachment := range attachments {
+		if posts.MediaInUse(attachment.Key) {
+			continue
+		}
+		if err := blobs.Delete(c.Request.Context(), attachment.Key); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// posts attaches blobs behind a single lock, like the memory store
+type posts struct {
+	mu       sync.Mutex
+	attached sync.Map
+}
+
+func (p *posts) MediaInUse(key string) bool {
+	_, ok := p.attached.Load(key)
+	return ok
+}
+
+func (p *posts) LockMedia(keys []string) (func(), bool) {
+	p.mu.Lock()
+	return p.mu.Unlock, true
+}
+
+func blobContext(t *testing.T, store BlobStore) *gin.Context {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("DELETE", "/", nil)
+	c.Set(StoreKey, store)
+	return c
+}
+
+func TestDeleteUnused(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "/media")
+	attachments := []models.Media{{Key: "posts/a.png"}, {Key: "posts/b.png"}}
+	for _, attachment := range attachments {
+		if _, err := store.Put(context.Background(), attachment.Key, "image/png", []byte("png")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var p posts
+	p.attached.Store("posts/b.png", true)
+	DeleteUnused(blobContext(t, store), attachments, &p)
+	if _, err := os.Stat(filepath.Join(dir, "posts", "a.png")); !os.IsNotExist(err) {
+		t.Errorf("unused blob kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "posts", "b.png")); err != nil {
+		t.Errorf("attached blob deleted: %v", err)
+	}
+}
+
+// A post attaching the blob while it is being deleted keeps it
+func TestDeleteUnusedWaitsForLock(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "/media")
+	attachment := models.Media{Key: "posts/a.png"}
+	var p posts
+	unlock, _ := p.LockMedia([]string{attachment.Key})
+	done := make(chan struct{})
+	go func() {
+		DeleteUnused(blobContext(t, store), []models.Media{attachment}, &p)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("blobs deleted while their keys were locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := store.Put(context.Background(), attachment.Key, "image/png", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	p.attached.Store(attachment.Key, true)
+	unlock()
+	<-done
+	if _, err := os.Stat(filepath.Join(dir, "posts", "a.png")); err != nil {
+		t.Errorf("attached blob deleted: %v", err)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	key, _ := Key("avatars", "image/png", []byte("png"))
+	for url, want := range map[string]string{
+		"http://localhost:8080/media/" + key:                         key,
+		"https://bucket.s3.amazonaws.com/tsuki/" + key:               key,
+		"https://cdn.discordapp.com/avatars/1234/abcd.png":           "",
+		"https://iili.io/abcd.png":                                   "",
+		"http://localhost:8080/media/" + key + ".exe":                "",
+		"http://localhost:8080/media/avatars/" + key[8:40]:           "",
+		"http://localhost:8080/media/posts/" + key[len("avatars/"):]: "",
+	} {
+		got, ok := KeyOf("avatars", url)
+		if got != want || ok != (want != "") {
+			t.Errorf("KeyOf(%q) = %q, %v, want %q", url, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package media
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const freeimageURL = "https://freeimage.host/api/1/upload"
+
+// FreeimageStore uploads images to Freeimage, which picks their URL. The
+// API can't delete images, they are left in place.
+type FreeimageStore struct {
+	apiKey string
+}
+
+func NewFreeimageStore(apiKey string) *FreeimageStore {
+	return &FreeimageStore{apiKey: apiKey}
+}
+
+type freeimageResponse struct {
+	StatusCode int `json:"status_code"`
+	Image      *struct {
+		URL string `json:"url"`
+	} `json:"image"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *FreeimageStore) Put(ctx context.Context, key string, contentType string, data []byte) (string, error) {
+	query := url.Values{"key": {s.apiKey}, "format": {"json"}}
+	form := url.Values{"source": {base64.StdEncoding.EncodeToString(data)}}
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, freeimageURL+"?"+query.Encode(), strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var body freeimageResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("freeimage: %s: %w", response.Status, err)
+	}
+	if body.StatusCode != http.StatusOK || body.Image == nil || body.Image.URL == "" {
+		if body.Error != nil {
+			return "", fmt.Errorf("freeimage: %s", body.Error.Message)
+		}
+		return "", fmt.Errorf("freeimage: upload failed with %s", response.Status)
+	}
+	return body.Image.URL, nil
+}
+
+func (s *FreeimageStore) Delete(ctx context.Context, key string) error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	// Decoders of the accepted formats
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// MaxImageSize limits the size of uploaded images
+	MaxImageSize = 10 << 20
+	// Images with more pixels are rejected before they are decoded
+	maxImagePixels = 25_000_000
+	jpegQuality    = 85
+)
+
+// Sides of the square avatars in pixels. Lists show avatars at 50px and
+// profiles at 130px, the sizes cover screens with twice the density.
+const (
+	AvatarSmall  = 100
+	AvatarMedium = 260
+	AvatarLarge  = 512
+)
+
+// Post images are scaled down to fit this side in pixels
+const PostImageSide = 2048
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image")
+	ErrImageTooLarge    = errors.New("image too large")
+)
+
+// DecodeImage checks that an upload is a JPEG, PNG, GIF or WebP image of a
+// sane size and decodes it. Only the first frame of animations is kept.
+// The EXIF orientation of JPEGs is returned, to apply with Orient after
+// scaling.
+func DecodeImage(data []byte) (image.Image, int, error) {
+	if len(data) > MaxImageSize {
+		return nil, 0, ErrImageTooLarge
+	}
+	if _, ok := extensions[http.DetectContentType(data)]; !ok {
+		return nil, 0, ErrUnsupportedImage
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, 0, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedImage
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+	return img, orientation, nil
+}
+
+// Thumbnail center-crops the image to a square and scales it down to the
+// size, smaller images aren't scaled up
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+	if side < size {
+		size = side
+	}
+	thumbnail := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, crop, draw.Src, nil)
+	return thumbnail
+}
+
+// Fit scales the image down to fit a square of the size, keeping its
+// aspect ratio
+func Fit(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+	}
+	// Keep a pixel of very narrow images
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = 1
+	}
+	fitted := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(fitted, fitted.Bounds(), img, bounds, draw.Src, nil)
+	return fitted
+}
+
+// Orient turns the image upright according to its EXIF orientation
+func Orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	// Orientations from 5 on swap the sides
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	oriented := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var srcX, srcY int
+			switch orientation {
+			case 2:
+				srcX, srcY = width-1-x, y
+			case 3:
+				srcX, srcY = width-1-x, height-1-y
+			case 4:
+				srcX, srcY = x, height-1-y
+			case 5:
+				srcX, srcY = y, x
+			case 6:
+				srcX, srcY = y, height-1-x
+			case 7:
+				srcX, srcY = width-1-y, height-1-x
+			case 8:
+				srcX, srcY = width-1-y, x
+			}
+			oriented.SetRGBA(x, y, img.RGBAAt(img.Bounds().Min.X+srcX, img.Bounds().Min.Y+srcY))
+		}
+	}
+	return oriented
+}
+
+// EncodeImage writes the pixels as a JPEG, or a PNG to keep transparency.
+// No metadata of the upload, such as EXIF or GPS tags, is carried over.
+func EncodeImage(img *image.RGBA) ([]byte, string, error) {
+	var buffer bytes.Buffer
+	if img.Opaque() {
+		if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, "", err
+		}
+		return buffer.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, "", err
+	}
+	return buffer.Bytes(), "image/png", nil
+}
+
+// exifOrientation reads the orientation tag from the EXIF segment of a
+// JPEG, 1 (upright) if there is none
+func exifOrientation(data []byte) int {
+	// Walk the segments after the start of image marker
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+		marker := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		// The image data starts with the start of scan marker
+		if marker == 0xDA || length < 2 || offset+2+length > len(data) {
+			break
+		}
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		offset += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for index := 0; index < entries; index++ {
+		entry := ifd + 2 + index*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Orientation is a SHORT stored in the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
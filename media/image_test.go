@@ -0,0 +1,187 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// exifSegment is an APP1 segment holding only the orientation tag
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// withExif inserts the segment after the start of image marker
+func withExif(jpegData []byte, segment []byte) []byte {
+	data := append([]byte{}, jpegData[:2]...)
+	data = append(data, segment...)
+	return append(data, jpegData[2:]...)
+}
+
+func TestDecodeImageSniffing(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	if _, orientation, err := DecodeImage(encodePNG(t, img)); err != nil || orientation != 1 {
+		t.Errorf("PNG: orientation %d, error %v", orientation, err)
+	}
+	for name, data := range map[string][]byte{
+		"text":      []byte("<html><body>not an image</body></html>"),
+		"svg":       []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		"bmp":       append([]byte("BM"), make([]byte, 64)...),
+		"truncated": encodePNG(t, img)[:20],
+	} {
+		if _, _, err := DecodeImage(data); err != ErrUnsupportedImage {
+			t.Errorf("%s: got error %v, want %v", name, err, ErrUnsupportedImage)
+		}
+	}
+}
+
+func TestDecodeImageLimits(t *testing.T) {
+	if _, _, err := DecodeImage(make([]byte, MaxImageSize+1)); err != ErrImageTooLarge {
+		t.Errorf("oversized upload: got error %v, want %v", err, ErrImageTooLarge)
+	}
+	// The header claims more pixels than allowed, the image is never decoded
+	data := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	binary.BigEndian.PutUint32(data[16:], 6000)
+	binary.BigEndian.PutUint32(data[20:], 5000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, _, err := DecodeImage(data); err != ErrImageTooLarge {
+		t.Errorf("30 megapixels: got error %v, want %v", err, ErrImageTooLarge)
+	}
+	binary.BigEndian.PutUint32(data[16:], 5000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, _, err := DecodeImage(data); err == ErrImageTooLarge {
+		t.Error("25 megapixels rejected as too large")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// Red, green and blue thirds, the green one is kept
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		for y := 0; y < 100; y++ {
+			img.Set(x, y, []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}[x/100])
+		}
+	}
+	for _, test := range []struct {
+		img  image.Image
+		size int
+		want int
+	}{
+		{img, 50, 50},
+		{img, 100, 100},
+		// Smaller images aren't scaled up
+		{img, 512, 100},
+		{img.SubImage(image.Rect(100, 0, 200, 40)), 100, 40},
+	} {
+		thumbnail := Thumbnail(test.img, test.size)
+		if bounds := thumbnail.Bounds(); bounds.Dx() != test.want || bounds.Dy() != test.want {
+			t.Errorf("thumbnail of %v at %d is %v, want %dx%d", test.img.Bounds(), test.size, bounds, test.want, test.want)
+			continue
+		}
+		for _, point := range []image.Point{{0, 0}, {test.want / 2, test.want / 2}, {test.want - 1, test.want - 1}} {
+			if got := thumbnail.RGBAAt(point.X, point.Y); got != (color.RGBA{0, 255, 0, 255}) {
+				t.Errorf("thumbnail of %v at %d has %v at %v, want the center crop", test.img.Bounds(), test.size, got, point)
+			}
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	a, b := color.RGBA{1, 0, 0, 255}, color.RGBA{2, 0, 0, 255}
+	c, d := color.RGBA{3, 0, 0, 255}, color.RGBA{4, 0, 0, 255}
+	// A B
+	// C D
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.SetRGBA(0, 0, a)
+	img.SetRGBA(1, 0, b)
+	img.SetRGBA(0, 1, c)
+	img.SetRGBA(1, 1, d)
+	for orientation, want := range map[int][4]color.RGBA{
+		0: {a, b, c, d},
+		1: {a, b, c, d},
+		2: {b, a, d, c},
+		3: {d, c, b, a},
+		4: {c, d, a, b},
+		5: {a, c, b, d},
+		6: {c, a, d, b},
+		7: {d, b, c, a},
+		8: {b, d, a, c},
+		9: {a, b, c, d},
+	} {
+		oriented := Orient(img, orientation)
+		got := [4]color.RGBA{oriented.RGBAAt(0, 0), oriented.RGBAAt(1, 0), oriented.RGBAAt(0, 1), oriented.RGBAAt(1, 1)}
+		if got != want {
+			t.Errorf("orientation %d: got %v, want %v", orientation, got, want)
+		}
+	}
+	// Orientations from 5 on swap the sides
+	wide := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for orientation := 1; orientation <= 8; orientation++ {
+		bounds := Orient(wide, orientation).Bounds()
+		width, height := 3, 2
+		if orientation >= 5 {
+			width, height = 2, 3
+		}
+		if bounds.Dx() != width || bounds.Dy() != height {
+			t.Errorf("orientation %d: got %v, want %dx%d", orientation, bounds, width, height)
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 4, 3)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buffer.Bytes()
+	little := exifSegment(binary.LittleEndian, 6)
+	for name, test := range map[string]struct {
+		data []byte
+		want int
+	}{
+		"no exif":       {plain, 1},
+		"little endian": {withExif(plain, little), 6},
+		"big endian":    {withExif(plain, exifSegment(binary.BigEndian, 8)), 8},
+		"bad order":     {withExif(plain, append(little[:10:10], append([]byte("XX"), little[12:]...)...)), 1},
+		"truncated":     {append([]byte{}, withExif(plain, little)[:20]...), 1},
+		"not a jpeg":    {[]byte("not a jpeg"), 1},
+	} {
+		if got := exifOrientation(test.data); got != test.want {
+			t.Errorf("%s: got orientation %d, want %d", name, got, test.want)
+		}
+	}
+	// The orientation of a JPEG upload is returned with it
+	if _, orientation, err := DecodeImage(withExif(plain, little)); err != nil || orientation != 6 {
+		t.Errorf("got orientation %d, error %v, want 6", orientation, err)
+	}
+}
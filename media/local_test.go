@@ -0,0 +1,72 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorePutDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "http://localhost:8080/media/")
+	ctx := context.Background()
+	url, err := store.Put(ctx, "avatars/abc.png", "image/png", []byte("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "http://localhost:8080/media/avatars/abc.png" {
+		t.Errorf("url = %s", url)
+	}
+	path := filepath.Join(dir, "avatars", "abc.png")
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "png" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+	// Nothing but the file is left in the directory
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+	if err := store.Delete(ctx, "avatars/abc.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists: %v", err)
+	}
+	// Deleting a missing file is not an error
+	if err := store.Delete(ctx, "avatars/abc.png"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "uploads")
+	store := NewLocalStore(dir, "/media")
+	ctx := context.Background()
+	for _, key := range []string{"", ".", "../secret", "avatars/../../secret", "../uploads-other/secret"} {
+		if _, err := store.path(key); err == nil {
+			t.Errorf("path(%q) was accepted", key)
+		}
+		if _, err := store.Put(ctx, key, "image/png", []byte("png")); err == nil {
+			t.Errorf("Put(%q) was accepted", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) was accepted", key)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) > 1 {
+		t.Errorf("files were written outside the store: %v", entries)
+	}
+}
+
+func TestKey(t *testing.T) {
+	key, ok := Key("avatars", "image/jpeg", []byte("jpeg"))
+	// SHA-256 of "jpeg"
+	if !ok || key != "avatars/41e5787e9f28562d07b891b1816b492309d646c0f2829743fa4963a9f9cc1d61.jpg" {
+		t.Errorf("Key = %s, %v", key, ok)
+	}
+	if _, ok := Key("avatars", "text/html; charset=utf-8", []byte("<html>")); ok {
+		t.Error("HTML was given a key")
+	}
+}
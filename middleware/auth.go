@@ -4,16 +4,35 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+// Purposes of tokens sent by mail, a token is only accepted for its own
+// purpose. Verification tokens issued before purposes existed have none.
+const (
+	PurposeVerify    = "verify"
+	PurposeReset     = "reset"
+	PurposeEmail     = "email"
+	PurposeEmailUndo = "email-undo"
+)
+
+// Claims of access tokens and mailed tokens, only access tokens are issued
+// for a session
 type JWTClaims struct {
-	UserId string
+	UserId    string
+	SessionId string `json:",omitempty"`
+	Purpose   string `json:",omitempty"`
+	// Address to switch to, for email change and undo tokens
+	Email string `json:",omitempty"`
 	jwt.StandardClaims
 }
 
@@ -21,6 +40,7 @@ var (
 	issuer          string
 	secretKey       []byte
 	errInvalidToken = errors.New("invalid token")
+	errNoSession    = errors.New("session revoked or expired")
 )
 
 func init() {
@@ -29,54 +49,143 @@ func init() {
 	secretKey = []byte(os.Getenv("SECRET_KEY"))
 }
 
-func CreateToken(id string) (string, error) {
+// CreateToken issues a short-lived access token for a session
+func CreateToken(userId string, sessionId string) (string, error) {
+	now := time.Now()
 	claims := JWTClaims{
-		id,
-		jwt.StandardClaims{
-			Issuer:   issuer,
-			IssuedAt: time.Now().Unix(),
+		UserId:    userId,
+		SessionId: sessionId,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenLifetime).Unix(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(secretKey)
 }
 
-func ParseToken(token string) (*JWTClaims, error) {
+// ParseToken verifies the signature and expiry of a token. Tokens issued
+// for a session are also rejected once the session is revoked or expired.
+func ParseToken(store database.Store, token string) (*JWTClaims, error) {
 	parsedToken, err := jwt.ParseWithClaims(token, &JWTClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errInvalidToken
+		}
 		return secretKey, nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	if claims, ok := parsedToken.Claims.(*JWTClaims); ok && parsedToken.Valid {
-		return claims, nil
+	claims, ok := parsedToken.Claims.(*JWTClaims)
+	if !ok || !parsedToken.Valid {
+		return nil, errInvalidToken
+	}
+	if claims.SessionId != "" {
+		session := store.ReadSession(claims.SessionId)
+		now := time.Now()
+		if session == nil || session.UserId != claims.UserId || !session.Active(now) {
+			return nil, errNoSession
+		}
+		if now.Sub(session.LastSeenAt) > touchInterval {
+			store.TouchSession(session.Id, now)
+		}
+	}
+	return claims, nil
+}
+
+// Prefix of personal access tokens, it tells them apart from session JWTs
+const AccessTokenPrefix = "tsk_"
+
+// ParseAccessToken returns the personal access token sent in an
+// Authorization: Bearer header, recording its use
+func ParseAccessToken(store database.Store, header string) *models.AccessToken {
+	raw := strings.TrimPrefix(header, "Bearer ")
+	if raw == header || !strings.HasPrefix(raw, AccessTokenPrefix) {
+		return nil
+	}
+	token := store.ReadAccessTokenByHash(internal.HashToken(raw))
+	if token == nil {
+		return nil
 	}
-	return nil, errInvalidToken
+	store.TouchAccessToken(token.Id, time.Now())
+	return token
 }
 
-func AuthMiddleware() func(c *gin.Context) {
+// HasScopes reports whether the token holds every scope, an empty list
+// means the route is not available to tokens
+func HasScopes(token *models.AccessToken, scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		if !token.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthMiddleware authenticates through the session cookie, or through a
+// personal access token holding every given scope. Routes that don't list
+// scopes can only be used from a session.
+func AuthMiddleware(scopes ...string) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
-		token := session.Get("Authorization")
-		if token == nil {
-			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
-				"error":   "401 Unauthorized",
-				"message": "User not logged in.",
-			})
-			c.Abort()
+		if header := c.GetHeader("Authorization"); header != "" {
+			token := ParseAccessToken(database.Default(c), header)
+			if token == nil {
+				c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+					"error":   "401 Unauthorized",
+					"message": "Invalid access token.",
+				})
+				c.Abort()
+				return
+			}
+			if !HasScopes(token, scopes) {
+				c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+					"error":   "403 Forbidden",
+					"message": "Access token does not have the required scope.",
+				})
+				c.Abort()
+				return
+			}
+			// The session is not saved, so token requests don't get a cookie
+			session.Set("userId", token.UserId)
+			c.Next()
 			return
 		}
-		parsedToken, err := ParseToken(token.(string))
-		if err != nil {
+		store := database.Default(c)
+		userId, sessionId, save := "", "", true
+		token, _ := session.Get("Authorization").(string)
+		if claims, err := ParseToken(store, token); err == nil && claims.SessionId != "" {
+			userId, sessionId = claims.UserId, claims.SessionId
+		} else if refresh, ok := session.Get("Refresh").(string); ok {
+			// The access token expired, rotate the refresh token
+			if tokens, err := RefreshSession(store, refresh, c.ClientIP()); err == nil {
+				userId, sessionId = tokens.UserId, tokens.SessionId
+				// A concurrent request already rotated the token, leave the
+				// cookie to its response
+				save = tokens.Refresh != ""
+				if save {
+					session.Set("Authorization", tokens.Access)
+					session.Set("Refresh", tokens.Refresh)
+				}
+			}
+		}
+		if userId == "" {
 			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
 				"error":   "401 Unauthorized",
-				"message": "Invalid authorization token, try logging in again.",
+				"message": "User not logged in, or the session has expired.",
 			})
 			c.Abort()
 			return
 		}
-		session.Set("userId", parsedToken.UserId)
-		session.Save()
+		session.Set("userId", userId)
+		session.Set("sessionId", sessionId)
+		if save {
+			session.Save()
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// Forms send the token in this field, scripts in the header
+const (
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRFToken returns the token of the session, creating it on first use.
+// Pages pass it to their forms as csrfToken.
+func CSRFToken(c *gin.Context) string {
+	session := sessions.Default(c)
+	if token, ok := session.Get("csrfToken").(string); ok {
+		return token
+	}
+	token := internal.NewToken("")
+	session.Set("csrfToken", token)
+	if err := session.Save(); err != nil {
+		log.Println(err)
+	}
+	return token
+}
+
+// Reports whether the Authorization header holds a session JWT or a
+// personal access token that is still valid
+func validAuthorization(store database.Store, header string) bool {
+	raw := strings.TrimPrefix(header, "Bearer ")
+	if raw == header {
+		return false
+	}
+	if strings.HasPrefix(raw, AccessTokenPrefix) {
+		return store.ReadAccessTokenByHash(internal.HashToken(raw)) != nil
+	}
+	claims, err := ParseToken(store, raw)
+	return err == nil && claims.SessionId != ""
+}
+
+// CSRFMiddleware rejects state-changing requests that don't carry the
+// token of the session. Requests authenticated with a valid bearer token
+// are exempt, browsers don't attach one on their own so it can't be forged
+// by another site, and an invalid one is refused as unauthorized. So are
+// the exempt routes, which must read their credentials from the body and
+// never from the cookie. It reads the store, so it runs after
+// StoreMiddleware.
+func CSRFMiddleware(exempt ...string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		for _, route := range exempt {
+			if c.FullPath() == route {
+				c.Next()
+				return
+			}
+		}
+		if header := c.GetHeader("Authorization"); header != "" {
+			if validAuthorization(database.Default(c), header) {
+				c.Next()
+				return
+			}
+			// Clients refresh their token on a 401, not on a missing CSRF token
+			if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+				c.Header("WWW-Authenticate", `Bearer realm="tsuki"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": gin.H{"status": http.StatusUnauthorized, "message": "Missing or invalid bearer token."},
+				})
+				return
+			}
+			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+				"error":   "401 Unauthorized",
+				"message": "Invalid access token.",
+			})
+			c.Abort()
+			return
+		}
+		expected, _ := sessions.Default(c).Get("csrfToken").(string)
+		token := c.GetHeader(CSRFHeader)
+		if token == "" {
+			token = c.PostForm(CSRFField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			// Scripts read the error from JSON
+			if c.GetHeader("X-Requested-With") == "XMLHttpRequest" || strings.HasPrefix(c.ContentType(), "application/json") ||
+				strings.HasPrefix(c.Request.URL.Path, "/api/") {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "Invalid or missing CSRF token, reload the page and try again.",
+				})
+				return
+			}
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Invalid or missing CSRF token, reload the page and try again.",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// csrfServer serves a form, a JSON API route and an exempt token route
+// behind CSRFMiddleware. GET /login starts a session of the test user.
+func csrfServer(t *testing.T, store database.Store) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.SetHTMLTemplate(template.Must(template.New("error.tmpl.html").Parse(`{{ .message }}`)))
+	app.Use(sessions.Sessions("tsuki", cookie.NewStore([]byte("test"))))
+	app.Use(StoreMiddleware(store))
+	app.Use(CSRFMiddleware("/api/v1/auth/token"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	app.GET("/login", func(c *gin.Context) {
+		if err := StartSession(c, testUserId); err != nil {
+			t.Error(err)
+		}
+	})
+	app.GET("/form", func(c *gin.Context) { c.String(http.StatusOK, CSRFToken(c)) })
+	app.POST("/form", ok)
+	app.POST("/api/v1/posts", ok)
+	app.POST("/api/v1/auth/token", ok)
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// csrfClient logs in and returns the CSRF token of the session
+func csrfClient(t *testing.T, server *httptest.Server) (*http.Client, string) {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	response, err := client.Get(server.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response, err = client.Get(server.URL + "/form"); err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	token, _ := io.ReadAll(response.Body)
+	return client, string(token)
+}
+
+func post(t *testing.T, client *http.Client, target string, form url.Values, header http.Header) int {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestCSRFForm(t *testing.T) {
+	store, _ := newSession(t)
+	server := csrfServer(t, store)
+	client, token := csrfClient(t, server)
+	if token == "" {
+		t.Fatal("no CSRF token")
+	}
+	for name, form := range map[string]url.Values{
+		"missing token": {},
+		"wrong token":   {CSRFField: {"wrong"}},
+	} {
+		if status := post(t, client, server.URL+"/form", form, nil); status != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want 403", name, status)
+		}
+	}
+	if status := post(t, client, server.URL+"/form", url.Values{CSRFField: {token}}, nil); status != http.StatusOK {
+		t.Errorf("got status %d with the token, want 200", status)
+	}
+	header := http.Header{CSRFHeader: {token}}
+	if status := post(t, client, server.URL+"/form", nil, header); status != http.StatusOK {
+		t.Errorf("got status %d with the token header, want 200", status)
+	}
+}
+
+func TestCSRFAPI(t *testing.T) {
+	store, tokens := newSession(t)
+	server := csrfServer(t, store)
+	client, _ := csrfClient(t, server)
+	// The session cookie alone doesn't exempt a request
+	if status := post(t, client, server.URL+"/api/v1/posts", nil, nil); status != http.StatusForbidden {
+		t.Errorf("cookie request got status %d, want 403", status)
+	}
+	for name, authorization := range map[string]string{
+		"invalid token": "Bearer invalid",
+		"unknown PAT":   "Bearer " + AccessTokenPrefix + "unknown",
+		"basic":         "Basic dXNlcjpwYXNz",
+	} {
+		header := http.Header{"Authorization": {authorization}}
+		if status := post(t, client, server.URL+"/api/v1/posts", nil, header); status != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want 401", name, status)
+		}
+	}
+
+	pat := AccessTokenPrefix + "secret"
+	if !store.CreateAccessToken(&models.AccessToken{
+		Id:        "token",
+		UserId:    testUserId,
+		Name:      "script",
+		Scopes:    []string{models.ScopeRead},
+		Hash:      internal.HashToken(pat),
+		CreatedAt: time.Now(),
+	}) {
+		t.Fatal("unable to create access token")
+	}
+	for name, bearer := range map[string]string{"session token": tokens.Access, "PAT": pat} {
+		header := http.Header{"Authorization": {"Bearer " + bearer}}
+		if status := post(t, http.DefaultClient, server.URL+"/api/v1/posts", nil, header); status != http.StatusOK {
+			t.Errorf("%s: got status %d, want 200", name, status)
+		}
+	}
+	if status := post(t, http.DefaultClient, server.URL+"/api/v1/auth/token", nil, nil); status != http.StatusOK {
+		t.Errorf("exempt route got status %d, want 200", status)
+	}
+}
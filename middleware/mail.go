@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"github.com/Devansh3712/tsuki-go/mail"
+	"github.com/gin-gonic/gin"
+)
+
+// Make the mail templates available to handlers through mail.Default
+func MailMiddleware(templates *mail.Templates) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set(mail.TemplatesKey, templates)
+		c.Next()
+	}
+}
@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/gin-gonic/gin"
+)
+
+// Make the blob store available to handlers through media.Default
+func MediaMiddleware(blobs media.BlobStore) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set(media.StoreKey, blobs)
+		c.Next()
+	}
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/mail"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of the requests guessing passwords and codes, the HTML and API
+// logins share their buckets
+var (
+	LoginIPLimit       = models.RateLimit{Burst: 10, Interval: 30 * time.Second}
+	LoginUsernameLimit = models.RateLimit{Burst: 5, Interval: time.Minute}
+	TwoFactorLimit     = models.RateLimit{Burst: 5, Interval: time.Minute}
+	SignUpIPLimit      = models.RateLimit{Burst: 5, Interval: 10 * time.Minute}
+	// Reset mails are limited per address too, so an inbox can't be flooded
+	// from many clients
+	ForgotIPLimit    = models.RateLimit{Burst: 10, Interval: time.Minute}
+	ForgotEmailLimit = models.RateLimit{Burst: 3, Interval: 20 * time.Minute}
+)
+
+const (
+	// The account is locked once this many logins fail within the window
+	lockoutThreshold = 10
+	lockoutWindow    = 15 * time.Minute
+	lockoutDuration  = 15 * time.Minute
+	// Buckets untouched for longer than any of them takes to refill are
+	// full, and are forgotten
+	pruneInterval = 10 * time.Minute
+	pruneAge      = time.Hour
+)
+
+// Make the rate limits available to handlers through database.RateLimits
+func RateLimitStoreMiddleware(limits database.RateLimitStore) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set(database.RateLimitStoreKey, limits)
+		c.Next()
+	}
+}
+
+// ClientIP keys a rate limit by the address of the client
+func ClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// FormValue keys a rate limit by a field of the posted form, such as the
+// username a login is for
+func FormValue(name string) func(c *gin.Context) string {
+	return func(c *gin.Context) string {
+		return strings.ToLower(strings.TrimSpace(c.PostForm(name)))
+	}
+}
+
+// Throttle takes a token from the bucket the key has in the named limit,
+// and otherwise returns how long until the request may be retried. Empty
+// keys aren't throttled.
+func Throttle(c *gin.Context, name string, limit models.RateLimit, key string) (bool, time.Duration) {
+	if key == "" {
+		return true, 0
+	}
+	// Keys are hashed so the table holds no usernames or addresses
+	return database.RateLimits(c).TakeToken(name+":"+internal.HashToken(key), limit, time.Now())
+}
+
+// RateLimitMiddleware renders the 429 page once the bucket of the key is
+// empty
+func RateLimitMiddleware(name string, limit models.RateLimit, key func(c *gin.Context) string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if ok, retryAfter := Throttle(c, name, limit, key(c)); !ok {
+			TooManyRequests(c, retryAfter)
+			return
+		}
+		c.Next()
+	}
+}
+
+// SetRetryAfter tells the client how many seconds to wait
+func SetRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+func TooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	SetRetryAfter(c, retryAfter)
+	wait := fmt.Sprintf("%d seconds", int(math.Ceil(retryAfter.Seconds())))
+	if retryAfter > time.Minute {
+		wait = fmt.Sprintf("%d minutes", int(math.Ceil(retryAfter.Minutes())))
+	}
+	c.HTML(http.StatusTooManyRequests, "error.tmpl.html", gin.H{
+		"error":   "429 Too Many Requests",
+		"message": "Too many attempts, try again in " + wait + ".",
+	})
+	c.Abort()
+}
+
+// LockedOut returns how long the account of the user stays locked
+func LockedOut(c *gin.Context, userId string) (bool, time.Duration) {
+	now := time.Now()
+	lockout := database.RateLimits(c).ReadLockout(userId)
+	if lockout == nil || !lockout.Locked(now) {
+		return false, 0
+	}
+	return true, lockout.LockedUntil.Sub(now)
+}
+
+// LoginFailed counts a wrong password or two-factor code. Too many of them
+// lock the account for a while, and the owner is mailed in case someone
+// else is guessing.
+func LoginFailed(c *gin.Context, user *models.User) {
+	limits := database.RateLimits(c)
+	now := time.Now()
+	if failures := limits.RecordLoginFailure(user.Id, now, lockoutWindow); failures < lockoutThreshold {
+		return
+	}
+	if !limits.LockUser(user.Id, now, now.Add(lockoutDuration)) {
+		return
+	}
+	log.Printf("locked user %s after %d failed logins", user.Id, lockoutThreshold)
+	message, err := mail.Default(c).Render("lockout", c.GetHeader("Accept-Language"), *user.Email, gin.H{
+		"username": user.Username,
+		"minutes":  int(lockoutDuration.Minutes()),
+		"link":     internal.BaseURL() + "/auth/forgot",
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	mail.Enqueue(database.Default(c), message, "lockout:"+user.Id)
+}
+
+// LoginSucceeded forgets the failed logins of the user
+func LoginSucceeded(c *gin.Context, userId string) {
+	database.RateLimits(c).DeleteLockout(userId)
+}
+
+// PruneRateLimits periodically forgets full buckets and old failures until
+// the context is cancelled
+func PruneRateLimits(ctx context.Context, limits database.RateLimitStore) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			limits.PruneRateLimits(now.Add(-pruneAge))
+		}
+	}
+}
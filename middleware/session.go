@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Access tokens are short-lived so that a copied one stops working
+	// without the refresh token
+	AccessTokenLifetime = 15 * time.Minute
+	// Sessions expire after this long without a refresh
+	RefreshTokenLifetime = 30 * 24 * time.Hour
+	// A refresh token replaced less than this long ago is still accepted
+	// once, without rotating, for a request that was sent concurrently
+	refreshGracePeriod = 30 * time.Second
+	// last_seen_at is only updated once per interval to avoid a write on
+	// every request
+	touchInterval = time.Minute
+)
+
+var (
+	errCreateSession = errors.New("unable to create session")
+	errRefreshReused = errors.New("refresh token reused")
+)
+
+// Tokens issued for a session. Refresh is empty when the refresh token
+// was used within the grace period, the client already holds a newer one.
+type SessionTokens struct {
+	UserId    string
+	SessionId string
+	Access    string
+	Refresh   string
+}
+
+// Refresh tokens are <session id>.<secret>, the id lets a reused token
+// revoke its session
+func newRefreshToken(sessionId string) string {
+	return sessionId + "." + internal.NewToken("")
+}
+
+func refreshSessionId(refresh string) string {
+	id, _, _ := strings.Cut(refresh, ".")
+	return id
+}
+
+// NewSession records a login of the user and issues its tokens
+func NewSession(store database.Store, userId string, userAgent string, ip string) (*SessionTokens, error) {
+	now := time.Now()
+	session := models.Session{
+		Id:         uuid.NewString(),
+		UserId:     userId,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		RotatedAt:  now,
+		ExpiresAt:  now.Add(RefreshTokenLifetime),
+	}
+	refresh := newRefreshToken(session.Id)
+	session.RefreshHash = internal.HashToken(refresh)
+	if !store.CreateSession(&session) {
+		return nil, errCreateSession
+	}
+	access, err := CreateToken(userId, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{
+		UserId:    userId,
+		SessionId: session.Id,
+		Access:    access,
+		Refresh:   refresh,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and
+// refresh token. Presenting a replaced refresh token after the grace
+// period, or a second time within it, means it was copied, and the whole
+// session is revoked.
+func RefreshSession(store database.Store, refresh string, ip string) (*SessionTokens, error) {
+	return refreshSession(store, refresh, ip, time.Now())
+}
+
+func refreshSession(store database.Store, refresh string, ip string, now time.Time) (*SessionTokens, error) {
+	session := store.ReadSession(refreshSessionId(refresh))
+	if session == nil || !session.Active(now) {
+		return nil, errNoSession
+	}
+	hash := internal.HashToken(refresh)
+	tokens := SessionTokens{UserId: session.UserId, SessionId: session.Id}
+	switch {
+	case hash == session.RefreshHash:
+		tokens.Refresh = newRefreshToken(session.Id)
+		if !store.RotateSession(
+			session.Id, hash, internal.HashToken(tokens.Refresh), ip, now, now.Add(RefreshTokenLifetime),
+		) {
+			return nil, errNoSession
+		}
+	case session.PreviousHash != nil && hash == *session.PreviousHash && now.Sub(session.RotatedAt) < refreshGracePeriod &&
+		store.UsePreviousHash(session.Id, hash):
+	default:
+		store.RevokeSession(session.UserId, session.Id)
+		return nil, errRefreshReused
+	}
+	access, err := CreateToken(session.UserId, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	tokens.Access = access
+	return &tokens, nil
+}
+
+// RevokeRefreshToken ends the session of a refresh token
+func RevokeRefreshToken(store database.Store, refresh string) bool {
+	session := store.ReadSession(refreshSessionId(refresh))
+	if session == nil {
+		return false
+	}
+	hash := internal.HashToken(refresh)
+	if hash != session.RefreshHash && (session.PreviousHash == nil || hash != *session.PreviousHash) {
+		return false
+	}
+	return store.RevokeSession(session.UserId, session.Id)
+}
+
+// StartSession logs the user in on this device, storing the session tokens
+// in the cookie
+func StartSession(c *gin.Context, userId string) error {
+	tokens, err := NewSession(database.Default(c), userId, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return err
+	}
+	session := sessions.Default(c)
+	session.Set("Authorization", tokens.Access)
+	session.Set("Refresh", tokens.Refresh)
+	// Forms rendered before the login stop working
+	session.Delete("csrfToken")
+	return session.Save()
+}
+
+// EndSession revokes the session stored in the cookie and clears it
+func EndSession(c *gin.Context) {
+	session := sessions.Default(c)
+	if refresh, ok := session.Get("Refresh").(string); ok {
+		RevokeRefreshToken(database.Default(c), refresh)
+	}
+	session.Clear()
+	session.Options(sessions.Options{MaxAge: -1})
+	session.Save()
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/models"
+)
+
+const testUserId = "user-0000-0000-0000-000000000000"
+
+// newSession creates a user in a memory store and logs them in
+func newSession(t *testing.T) (database.Store, *SessionTokens) {
+	t.Helper()
+	store := database.NewMemoryStore()
+	user := &models.User{
+		Id:        testUserId,
+		Username:  "user",
+		CreatedAt: time.Now(),
+	}
+	if !store.CreateUser(user) {
+		t.Fatal("unable to create user")
+	}
+	tokens, err := NewSession(store, user.Id, "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, tokens
+}
+
+func TestRefreshSessionRotates(t *testing.T) {
+	store, tokens := newSession(t)
+	refreshed, err := refreshSession(store, tokens.Refresh, "127.0.0.1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Refresh == "" || refreshed.Refresh == tokens.Refresh {
+		t.Fatalf("refresh token %q was not rotated", refreshed.Refresh)
+	}
+	if refreshed.Access == "" || refreshed.SessionId != tokens.SessionId {
+		t.Errorf("got tokens %+v for session %s", refreshed, tokens.SessionId)
+	}
+	// The new token rotates in turn
+	if _, err := refreshSession(store, refreshed.Refresh, "127.0.0.1", time.Now()); err != nil {
+		t.Errorf("rotated token refused: %v", err)
+	}
+}
+
+func TestRefreshSessionGracePeriod(t *testing.T) {
+	store, tokens := newSession(t)
+	now := time.Now()
+	refreshed, err := refreshSession(store, tokens.Refresh, "127.0.0.1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concurrent, err := refreshSession(store, tokens.Refresh, "127.0.0.1", now.Add(refreshGracePeriod/2))
+	if err != nil {
+		t.Fatalf("old token refused inside the grace period: %v", err)
+	}
+	if concurrent.Refresh != "" || concurrent.Access == "" {
+		t.Errorf("got tokens %+v, want an access token without rotating", concurrent)
+	}
+	_, err = refreshSession(store, tokens.Refresh, "127.0.0.1", now.Add(refreshGracePeriod/2))
+	if !errors.Is(err, errRefreshReused) {
+		t.Fatalf("old token used twice, got error %v", err)
+	}
+	if _, err := refreshSession(store, refreshed.Refresh, "127.0.0.1", now); err == nil {
+		t.Error("session not revoked after the old token was used twice")
+	}
+}
+
+func TestRefreshSessionReuseRevokes(t *testing.T) {
+	store, tokens := newSession(t)
+	now := time.Now()
+	refreshed, err := refreshSession(store, tokens.Refresh, "127.0.0.1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = refreshSession(store, tokens.Refresh, "127.0.0.1", now.Add(refreshGracePeriod))
+	if !errors.Is(err, errRefreshReused) {
+		t.Fatalf("old token after the grace period, got error %v", err)
+	}
+	if session := store.ReadSession(tokens.SessionId); session == nil || session.RevokedAt == nil {
+		t.Fatal("session not revoked")
+	}
+	if _, err := refreshSession(store, refreshed.Refresh, "127.0.0.1", now); err == nil {
+		t.Error("current token accepted after the session was revoked")
+	}
+}
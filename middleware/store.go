@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Make the store available to handlers through database.Default
+func StoreMiddleware(store database.Store) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.Set(database.StoreKey, store)
+		c.Next()
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// Users have this long after entering their password to enter the code
+const twoFactorTimeout = 5 * time.Minute
+
+var (
+	ErrTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorExpired = errors.New("two-factor login expired")
+)
+
+func TwoFactorEnabled(store database.Store, userId string) bool {
+	twoFactor := store.ReadTwoFactor(userId)
+	return twoFactor != nil && twoFactor.Enabled
+}
+
+// CheckTwoFactor verifies a TOTP code or an unused recovery code, each code
+// is only accepted once
+func CheckTwoFactor(store database.Store, userId string, code string) bool {
+	twoFactor := store.ReadTwoFactor(userId)
+	if twoFactor == nil || !twoFactor.Enabled {
+		return false
+	}
+	if step, ok := internal.VerifyTOTP(twoFactor.Secret, code, time.Now()); ok {
+		return store.UseTOTPStep(userId, step)
+	}
+	return store.UseRecoveryCode(userId, internal.HashToken(internal.NormalizeRecoveryCode(code)))
+}
+
+// BeginLogin logs the user in after the password or OAuth check. With
+// two-factor authentication enabled the user is only remembered in the
+// cookie until FinishLogin verifies the code, it reports whether a code is
+// needed.
+func BeginLogin(c *gin.Context, userId string) (bool, error) {
+	if !TwoFactorEnabled(database.Default(c), userId) {
+		LoginSucceeded(c, userId)
+		return false, StartSession(c, userId)
+	}
+	session := sessions.Default(c)
+	session.Set("twoFactorUserId", userId)
+	session.Set("twoFactorAt", time.Now().Unix())
+	return true, session.Save()
+}
+
+// PendingLogin reports whether a login is waiting for its two-factor code
+func PendingLogin(c *gin.Context) bool {
+	return PendingUserId(c) != ""
+}
+
+// PendingUserId returns the user of the login waiting for its code
+func PendingUserId(c *gin.Context) string {
+	session := sessions.Default(c)
+	userId, _ := session.Get("twoFactorUserId").(string)
+	return userId
+}
+
+// FinishLogin verifies the code of a pending login and starts its session
+func FinishLogin(c *gin.Context, code string) error {
+	session := sessions.Default(c)
+	userId, _ := session.Get("twoFactorUserId").(string)
+	startedAt, _ := session.Get("twoFactorAt").(int64)
+	if userId == "" || time.Since(time.Unix(startedAt, 0)) > twoFactorTimeout {
+		session.Delete("twoFactorUserId")
+		session.Delete("twoFactorAt")
+		session.Save()
+		return ErrTwoFactorExpired
+	}
+	store := database.Default(c)
+	if !CheckTwoFactor(store, userId, code) {
+		if user := store.ReadUserById(userId); user != nil {
+			LoginFailed(c, user)
+		}
+		return ErrTwoFactorCode
+	}
+	session.Delete("twoFactorUserId")
+	session.Delete("twoFactorAt")
+	LoginSucceeded(c, userId)
+	return StartSession(c, userId)
+}
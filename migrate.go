@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+)
+
+const migrateUsage = "usage: tsuki-go migrate up|down|status"
+
+// Run the migrate subcommand against POSTGRES_URI
+func migrate(args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	store, err := database.NewPostgresStore(os.Getenv("POSTGRES_URI"))
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		reverted, err := store.MigrateDown()
+		if err != nil {
+			return err
+		}
+		if reverted == nil {
+			fmt.Println("no migrations to revert")
+			return nil
+		}
+		fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		status, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, migration := range status {
+			appliedAt := "pending"
+			if migration.AppliedAt != nil {
+				appliedAt = internal.FormatAsDate(*migration.AppliedAt)
+			}
+			fmt.Printf("%04d_%-30s %s\n", migration.Version, migration.Name, appliedAt)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
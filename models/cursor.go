@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Cursor is a keyset position in a newest-first listing, a page continues
+// with the rows that come after the row it was created from
+type Cursor struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+// Precedes reports whether a row at position next comes after the cursor
+// when rows are ordered by created_at DESC, id DESC. A nil cursor is the
+// start of the listing.
+func (c *Cursor) Precedes(next Cursor) bool {
+	if c == nil {
+		return true
+	}
+	if next.CreatedAt.Equal(c.CreatedAt) {
+		return next.Id < c.Id
+	}
+	return next.CreatedAt.Before(c.CreatedAt)
+}
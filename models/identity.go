@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Identity links an account at a login provider to a user, the provider
+// and the subject id it gives the account identify it. A user has at most
+// one identity per provider.
+type Identity struct {
+	Provider   string
+	Subject    string
+	UserId     string
+	Email      *string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Mail is a message in the outbox. It is pending until it is sent or dead,
+// a dead mail failed too many times and is only retried by hand. Pending
+// mails with the same DedupKey are merged into one unless a worker claimed
+// it until ClaimedUntil, Version counts the merges.
+type Mail struct {
+	Id            string
+	Recipient     string
+	Subject       string
+	HTML          string
+	Text          string
+	DedupKey      *string
+	Attempts      int
+	LastError     *string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	SentAt        *time.Time
+	DeadAt        *time.Time
+	Version       int
+	ClaimedUntil  *time.Time
+}
+
+func (m *Mail) Pending() bool {
+	return m.SentAt == nil && m.DeadAt == nil
+}
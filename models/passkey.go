@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Passkey is a WebAuthn credential registered by a user, Id is the
+// base64url encoded credential id
+type Passkey struct {
+	Id         string
+	UserId     string
+	Name       string `form:"name" binding:"required,max=64"`
+	Credential webauthn.Credential
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func PasskeyId(credentialId []byte) string {
+	return base64.RawURLEncoding.EncodeToString(credentialId)
+}
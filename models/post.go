@@ -5,18 +5,53 @@ import "time"
 type Post struct {
 	UserId    string
 	Id        string
-	Body      string `form:"body" binding:"required"`
+	Body      string `form:"body" binding:"required,max=320"`
 	Username  string
 	Avatar    *string
+	Media     []Media
+	EditedAt  *time.Time
 	CreatedAt time.Time
 }
 
+// Revision is a body the post had before an edit, written at CreatedAt and
+// replaced at ReplacedAt
+type Revision struct {
+	PostId     string
+	Id         string
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+// Media is an image attached to a post, stored under Key in the blob store
+type Media struct {
+	PostId      string
+	Position    int
+	Key         string
+	URL         string
+	ContentType string
+	Alt         string
+}
+
 type Comment struct {
 	UserId    string
 	PostId    string
 	Id        string
-	Body      string `form:"body" binding:"required"`
+	Body      string `form:"body" binding:"required,max=320"`
 	Username  string
 	Self      bool
 	CreatedAt time.Time
 }
+
+// Editable tells if the post was created within the window before now
+func (p Post) Editable(window time.Duration, now time.Time) bool {
+	return now.Sub(p.CreatedAt) <= window
+}
+
+func (p Post) Cursor() Cursor {
+	return Cursor{CreatedAt: p.CreatedAt, Id: p.Id}
+}
+
+func (c Comment) Cursor() Cursor {
+	return Cursor{CreatedAt: c.CreatedAt, Id: c.Id}
+}
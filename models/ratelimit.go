@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// RateLimit is a token bucket holding up to Burst requests, refilled with
+// one request every Interval
+type RateLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// Lockout counts the failed logins of a user since FirstFailedAt, the
+// account is locked until LockedUntil after too many of them
+type Lockout struct {
+	UserId        string
+	Failures      int
+	FirstFailedAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (l *Lockout) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
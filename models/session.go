@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Session is a login of a user on a device. Access tokens carry the id of
+// their session, so revoking it invalidates them. Only the SHA-256 hash of
+// the refresh token is stored, it is replaced every time it is used.
+type Session struct {
+	Id           string
+	UserId       string
+	RefreshHash  string
+	PreviousHash *string
+	UserAgent    string
+	IP           string
+	CreatedAt    time.Time
+	LastSeenAt   time.Time
+	RotatedAt    time.Time
+	ExpiresAt    time.Time
+	RevokedAt    *time.Time
+}
+
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
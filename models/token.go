@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Scopes that can be granted to personal access tokens
+const (
+	ScopeRead         = "read"
+	ScopeWritePosts   = "write:posts"
+	ScopeWriteFollows = "write:follows"
+)
+
+var Scopes = []string{ScopeRead, ScopeWritePosts, ScopeWriteFollows}
+
+// AccessToken is a personal access token, only the SHA-256 hash of the
+// token is stored
+type AccessToken struct {
+	Id         string
+	UserId     string
+	Name       string `form:"name" binding:"required,max=64"`
+	Scopes     []string
+	Hash       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
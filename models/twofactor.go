@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// TwoFactor is the TOTP enrollment of a user, it is pending until the
+// first code is confirmed. LastStep is the last accepted time step, codes
+// can't be used twice.
+type TwoFactor struct {
+	UserId    string
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt time.Time
+}
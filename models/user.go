@@ -7,13 +7,16 @@ import (
 )
 
 type User struct {
-	Email     *string `form:"email" binding:"required"`
-	Username  string  `form:"username" binding:"required"`
-	Password  string  `form:"password" binding:"required"`
-	Id        string
-	Verified  bool
-	Avatar    *string
-	CreatedAt time.Time
+	Email    *string `form:"email" binding:"required"`
+	Username string  `form:"username" binding:"required"`
+	Password string  `form:"password" binding:"required" json:"-"`
+	Id       string
+	Verified bool
+	// Avatar is the largest size, profiles and lists show the smaller ones
+	Avatar       *string
+	AvatarMedium *string
+	AvatarSmall  *string
+	CreatedAt    time.Time
 }
 
 type DiscordUser struct {
@@ -25,17 +28,27 @@ type DiscordUser struct {
 }
 
 type GitHubUser struct {
-	Email    *string `json:"email"`
 	Username string  `json:"login"`
-	Verified bool
 	Avatar   *string `json:"avatar_url"`
+	GitHubId int64   `json:"id"`
+}
+
+type GitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
 }
 
-type GoogleUser struct {
-	Email    string  `json:"email"`
-	Username string  `json:"given_name"`
-	Avatar   *string `json:"picture"`
-	Verified bool    `json:"email_verified"`
+// OIDCUser holds the standard claims returned by an OpenID Connect userinfo
+// endpoint
+type OIDCUser struct {
+	Subject           string  `json:"sub"`
+	PreferredUsername string  `json:"preferred_username"`
+	GivenName         string  `json:"given_name"`
+	Name              string  `json:"name"`
+	Email             *string `json:"email"`
+	EmailVerified     bool    `json:"email_verified"`
+	Picture           *string `json:"picture"`
 }
 
 type Login struct {
@@ -43,6 +56,10 @@ type Login struct {
 	Password string `form:"password" binding:"required"`
 }
 
+func (u User) Cursor() Cursor {
+	return Cursor{CreatedAt: u.CreatedAt, Id: u.Id}
+}
+
 func (u *User) HashPassword() error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -52,6 +69,12 @@ func (u *User) HashPassword() error {
 	return nil
 }
 
+// Accounts created through a login provider have no password until the
+// user sets one
+func (u *User) HasPassword() bool {
+	return u.Password != ""
+}
+
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
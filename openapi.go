@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/docs"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/gin-gonic/gin"
+)
+
+const openapiUsage = "usage: tsuki-go openapi generate|check"
+
+// Run the openapi subcommand, generate rewrites docs/openapi.json and check
+// fails if it is out of date or a JSON route is undocumented
+func openapi(args []string) error {
+	if len(args) != 1 {
+		return errors.New(openapiUsage)
+	}
+	switch args[0] {
+	case "generate":
+		document, err := docs.Generate()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile("docs/openapi.json", document, 0644)
+	case "check":
+		gin.SetMode(gin.ReleaseMode)
+		app := newRouter(database.NewMemoryStore(), database.NewMemoryRateLimitStore(), media.NewLocalStore("uploads", "/media"))
+		errs := docs.Check(app.Routes())
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("openapi check failed with %d errors", len(errs))
+		}
+		fmt.Println("openapi.json is up to date")
+	default:
+		return errors.New(openapiUsage)
+	}
+	return nil
+}
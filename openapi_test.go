@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/docs"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/gin-gonic/gin"
+)
+
+// The committed OpenAPI document must cover every JSON route and match the
+// handler types
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newRouter(database.NewMemoryStore(), database.NewMemoryRateLimitStore(), media.NewLocalStore(t.TempDir(), "/media"))
+	for _, err := range docs.Check(app.Routes()) {
+		t.Error(err)
+	}
+}
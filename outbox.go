@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+)
+
+const outboxUsage = "usage: tsuki-go outbox dead|retry <id>"
+
+// Run the outbox subcommand against POSTGRES_URI, it lists the mails that
+// failed every attempt and queues them again
+func outbox(args []string) error {
+	if len(args) == 0 {
+		return errors.New(outboxUsage)
+	}
+	store, err := database.NewPostgresStore(os.Getenv("POSTGRES_URI"))
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "dead":
+		mails := store.ReadDeadMail(100)
+		if len(mails) == 0 {
+			fmt.Println("no dead mail")
+		}
+		for _, mail := range mails {
+			lastError := ""
+			if mail.LastError != nil {
+				lastError = *mail.LastError
+			}
+			fmt.Printf("%s %s %-30s %q\n  %s\n", mail.Id, internal.FormatAsDate(*mail.DeadAt), mail.Recipient, mail.Subject, lastError)
+		}
+	case "retry":
+		if len(args) != 2 {
+			return errors.New(outboxUsage)
+		}
+		if !store.RetryMail(args[1], time.Now()) {
+			return fmt.Errorf("mail %s is not dead or a newer one is pending", args[1])
+		}
+		fmt.Printf("queued %s\n", args[1])
+	default:
+		return errors.New(outboxUsage)
+	}
+	return nil
+}
@@ -1,16 +1,21 @@
 package routes
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	socials "github.com/Devansh3712/tsuki-go/internal/auth"
 	"github.com/Devansh3712/tsuki-go/middleware"
 	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
@@ -18,19 +23,28 @@ import (
 var (
 	issuer    string
 	secretKey []byte
+	// Public address of the app, used in mailed links and as the passkey
+	// relying party
+	baseURL  string
+	webAuthn *webauthn.WebAuthn
 )
 
 func init() {
 	godotenv.Load(".env")
 	issuer = os.Getenv("ISSUER")
 	secretKey = []byte(os.Getenv("SECRET_KEY"))
+	baseURL = internal.BaseURL()
+	webAuthn = newWebAuthn(baseURL)
 }
 
 func SignUp(c *gin.Context) {
+	store := database.Default(c)
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "auth.tmpl.html", gin.H{
-			"type": "signup",
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "signup",
+			"providers": socials.Providers(),
 		})
 	case "POST":
 		var user models.User
@@ -48,7 +62,7 @@ func SignUp(c *gin.Context) {
 			})
 			return
 		}
-		if user := database.ReadUserByName(user.Username); user != nil {
+		if user := store.ReadUserByName(user.Username); user != nil {
 			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
 				"error":   "403 Forbidden",
 				"message": "Account already exists with the given username.",
@@ -59,27 +73,40 @@ func SignUp(c *gin.Context) {
 		user.Id = uuid.NewString()
 		user.Verified = false
 		user.HashPassword()
-		if res := database.CreateUser(&user); !res {
+		if res := store.CreateUser(&user); !res {
 			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
 				"error":   "403 Forbidden",
 				"message": "Account already exists with the given email.",
 			})
 			return
 		}
-		// Set authorization token for user
-		token, _ := middleware.CreateToken(user.Id)
-		session := sessions.Default(c)
-		session.Set("Authorization", token)
-		session.Save()
-		c.Redirect(http.StatusFound, "/auth/verify?signup=true")
+		if err := middleware.StartSession(c, user.Id); err != nil {
+			log.Println(err)
+			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+				"error":   "500 Internal Server Error",
+				"message": "Unable to log in, try again later.",
+			})
+			return
+		}
+		// The verify page lets the user ask again if the mail can't be queued
+		if result := sendVerificationLink(c, &user); !result {
+			c.Redirect(http.StatusFound, "/auth/verify?signup=true")
+			return
+		}
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": fmt.Sprintf("Account created succesfully. Verification mail sent to %s", *user.Email),
+		})
 	}
 }
 
 func Login(c *gin.Context) {
+	store := database.Default(c)
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "auth.tmpl.html", gin.H{
-			"type": "login",
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "login",
+			"providers": socials.Providers(),
 		})
 	case "POST":
 		var login models.Login
@@ -97,7 +124,7 @@ func Login(c *gin.Context) {
 			})
 			return
 		}
-		user := database.ReadUserByName(login.Username)
+		user := store.ReadUserByName(login.Username)
 		if user == nil {
 			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
 				"error":   "401 Unauthorized",
@@ -105,36 +132,78 @@ func Login(c *gin.Context) {
 			})
 			return
 		}
+		if locked, retryAfter := middleware.LockedOut(c, user.Id); locked {
+			middleware.TooManyRequests(c, retryAfter)
+			return
+		}
 		if !user.CheckPassword(login.Password) {
+			middleware.LoginFailed(c, user)
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "401 Unauthorized",
 				"message": "Incorrect password.",
 			})
 			return
 		}
-		token, _ := middleware.CreateToken(user.Id)
-		session := sessions.Default(c)
-		session.Set("Authorization", token)
-		session.Save()
+		twoFactor, err := middleware.BeginLogin(c, user.Id)
+		if err != nil {
+			log.Println(err)
+			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+				"error":   "500 Internal Server Error",
+				"message": "Unable to log in, try again later.",
+			})
+			return
+		}
+		if twoFactor {
+			c.Redirect(http.StatusFound, "/auth/login/2fa")
+			return
+		}
 		c.Redirect(http.StatusFound, "/feed")
 	}
 }
 
+// Logout asks to log out on GET, so that following a link can't end the
+// session, and revokes the session on POST
 func Logout(c *gin.Context) {
 	session := sessions.Default(c)
-	id := session.Get("userId")
-	if id == nil {
+	if refresh := session.Get("Refresh"); refresh == nil {
 		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
 			"error":   "401 Unauthorized",
 			"message": "User not logged in.",
 		})
 		return
 	}
-	// Remove all session headers
-	session.Clear()
-	session.Options(sessions.Options{MaxAge: -1})
-	session.Save()
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "confirm.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"title":     "Log Out",
+			"message":   "Log out of Tsuki on this device.",
+			"action":    "/logout",
+			"button":    "Log out",
+		})
+	case "POST":
+		// Revoke the session and remove all session headers
+		middleware.EndSession(c)
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": "Logged out successfully.",
+		})
+	}
+}
+
+// Revoke every session of the user, including the current one
+func LogoutEverywhere(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId").(string)
+	if result := store.RevokeSessions(id, ""); !result {
+		c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+			"error":   "500 Internal Server Error",
+			"message": "Unable to sign out of all devices, try again later.",
+		})
+		return
+	}
+	middleware.EndSession(c)
 	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
-		"message": "Logged out successfully.",
+		"message": "Signed out of all devices.",
 	})
 }
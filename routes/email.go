@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	emailChangeLifetime = 24 * time.Hour
+	emailUndoLifetime   = 7 * 24 * time.Hour
+)
+
+// mailLink stores a token under a new link id and returns the link
+func mailLink(store database.Store, claims middleware.JWTClaims, lifetime time.Duration, path string) (string, error) {
+	token, err := createMailedToken(claims, lifetime)
+	if err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	if result := store.CreateVerificationId(token, id, claims.UserId, claims.Purpose); !result {
+		return "", fmt.Errorf("unable to store link for %s", claims.Purpose)
+	}
+	return fmt.Sprintf("%s%s/%s", baseURL, path, id), nil
+}
+
+// UpdateEmail sends a confirmation link to the new address, the email is
+// only changed once it is confirmed
+func UpdateEmail(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	// The change is confirmed with the password
+	if user := store.ReadUserById(id.(string)); !user.HasPassword() {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Set a password before changing the email.",
+		})
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "update.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "email",
+			"twoFactor": middleware.TwoFactorEnabled(store, id.(string)),
+		})
+	case "POST":
+		user := store.ReadUserById(id.(string))
+		newEmail := c.PostForm("email")
+		if !user.CheckPassword(c.PostForm("password")) {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Incorrect password.",
+			})
+			return
+		}
+		if middleware.TwoFactorEnabled(store, user.Id) && !middleware.CheckTwoFactor(store, user.Id, c.PostForm("code")) {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Incorrect two-factor code.",
+			})
+			return
+		}
+		if newEmail == "" || (user.Email != nil && *user.Email == newEmail) {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "New email cannot be the same as current.",
+			})
+			return
+		}
+		if exists := store.ReadUserByEmail(newEmail); exists != nil {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Account already exists with the given email.",
+			})
+			return
+		}
+		link, err := mailLink(store, middleware.JWTClaims{
+			UserId:  user.Id,
+			Purpose: middleware.PurposeEmail,
+			Email:   newEmail,
+		}, emailChangeLifetime, "/auth/email")
+		// A newer request replaces a confirmation that wasn't sent yet
+		if err == nil && !queueMail(c, "email", newEmail, gin.H{
+			"username": user.Username,
+			"email":    newEmail,
+			"link":     link,
+		}, "email:"+user.Id) {
+			err = errors.New("unable to queue confirmation mail")
+		}
+		if err != nil {
+			log.Println(err)
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to send confirmation mail, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": fmt.Sprintf("Confirmation mail sent to %s", newEmail),
+		})
+	}
+}
+
+// ConfirmEmail switches the account to the confirmed address and sends the
+// previous address a link to undo the change
+func ConfirmEmail(c *gin.Context) {
+	store := database.Default(c)
+	linkId := c.Param("id")
+	claims := readMailedToken(store, linkId, middleware.PurposeEmail)
+	if claims == nil || !store.DeleteVerificationId(linkId) {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Confirmation link is invalid or has expired.",
+		})
+		return
+	}
+	user := store.ReadUserById(claims.UserId)
+	if user == nil {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "User does not exist.",
+		})
+		return
+	}
+	// The link proved the new address, so it counts as verified
+	if result := store.UpdateUser(user.Id, map[string]any{
+		"email":    claims.Email,
+		"verified": true,
+	}); !result {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Account already exists with the given email.",
+		})
+		return
+	}
+	if user.Email != nil {
+		link, err := mailLink(store, middleware.JWTClaims{
+			UserId:  user.Id,
+			Purpose: middleware.PurposeEmailUndo,
+			Email:   *user.Email,
+		}, emailUndoLifetime, "/auth/email/undo")
+		if err == nil && !queueMail(c, "email-undo", *user.Email, gin.H{
+			"username": user.Username,
+			"email":    claims.Email,
+			"link":     link,
+		}, "") {
+			err = errors.New("unable to queue email change notice")
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+		"message": "Email updated successfully.",
+	})
+}
+
+// UndoEmail restores the previous address from the notice sent to it. The
+// change may not have been made by the owner, so every session, personal
+// access token and pending email change or reset link is revoked.
+func UndoEmail(c *gin.Context) {
+	store := database.Default(c)
+	linkId := c.Param("id")
+	claims := readMailedToken(store, linkId, middleware.PurposeEmailUndo)
+	if claims == nil || !store.DeleteVerificationId(linkId) {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Undo link is invalid or has expired.",
+		})
+		return
+	}
+	if result := store.UpdateUser(claims.UserId, map[string]any{
+		"email":    claims.Email,
+		"verified": true,
+	}); !result {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Unable to restore the email, it is used by another account.",
+		})
+		return
+	}
+	store.RevokeSessions(claims.UserId, "")
+	store.DeleteAccessTokens(claims.UserId)
+	store.DeleteVerificationIds(claims.UserId, []string{middleware.PurposeEmail, middleware.PurposeReset})
+	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+		"message": "Email restored and every device signed out. Reset your password if you didn't make the change.",
+	})
+}
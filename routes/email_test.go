@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// pageServer serves the handler with stand-ins for the page templates
+func pageServer(t *testing.T, store database.Store, method string, route string, handler gin.HandlerFunc) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.SetHTMLTemplate(template.Must(template.New("").Parse(
+		`{{ define "error.tmpl.html" }}{{ .message }}{{ end }}` +
+			`{{ define "response.tmpl.html" }}{{ .message }}{{ end }}`,
+	)))
+	app.Use(sessions.Sessions("tsuki", cookie.NewStore([]byte("test"))))
+	app.Use(middleware.StoreMiddleware(store))
+	app.Handle(method, route, handler)
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUndoEmailRevokesAccess(t *testing.T) {
+	store := database.NewMemoryStore()
+	user := createUser(t, store, "alice")
+	token := &models.AccessToken{
+		Id:        "token",
+		UserId:    user.Id,
+		Name:      "script",
+		Scopes:    []string{models.ScopeRead},
+		Hash:      internal.HashToken("tsk_secret"),
+		CreatedAt: time.Now(),
+	}
+	if !store.CreateAccessToken(token) {
+		t.Fatal("unable to create access token")
+	}
+	links := map[string]string{}
+	for _, purpose := range []string{middleware.PurposeEmail, middleware.PurposeReset, middleware.PurposeVerify, middleware.PurposeEmailUndo} {
+		link, err := mailLink(store, middleware.JWTClaims{
+			UserId:  user.Id,
+			Purpose: purpose,
+			Email:   "alice@example.com",
+		}, time.Hour, "/auth/"+purpose)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[purpose] = path.Base(link)
+	}
+	store.UpdateUser(user.Id, map[string]any{"email": "mallory@example.com"})
+
+	server := pageServer(t, store, "GET", "/auth/email/undo/:id", UndoEmail)
+	response, err := http.Get(server.URL + "/auth/email/undo/" + links[middleware.PurposeEmailUndo])
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", response.StatusCode)
+	}
+	if email := store.ReadUserById(user.Id).Email; *email != "alice@example.com" {
+		t.Errorf("email = %s, want alice@example.com", *email)
+	}
+	if store.ReadAccessTokenByHash(token.Hash) != nil {
+		t.Error("access token wasn't revoked")
+	}
+	for purpose, kept := range map[string]bool{
+		middleware.PurposeEmail:     false,
+		middleware.PurposeReset:     false,
+		middleware.PurposeVerify:    true,
+		middleware.PurposeEmailUndo: false,
+	} {
+		if got := store.ReadVerificationId(links[purpose]) != ""; got != kept {
+			t.Errorf("%s link kept = %v, want %v", purpose, got, kept)
+		}
+	}
+}
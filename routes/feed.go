@@ -3,14 +3,14 @@ package routes
 import (
 	"net/http"
 
+	"github.com/Devansh3712/tsuki-go/api"
 	"github.com/Devansh3712/tsuki-go/database"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-var feedLimit = 10
-
 func UserFeed(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -20,28 +20,35 @@ func UserFeed(c *gin.Context) {
 		})
 		return
 	}
-	feedLimit = 10
-	posts := database.ReadFeedPosts(id.(string), 10, 0)
+	posts := store.ReadFeedPosts(id.(string), pageSize, nil)
 	for index := range posts {
-		author := database.ReadUserById(posts[index].UserId)
+		author := store.ReadUserById(posts[index].UserId)
 		posts[index].Username = author.Username
-		posts[index].Avatar = author.Avatar
+		posts[index].Avatar = author.AvatarSmall
 	}
 	c.HTML(http.StatusOK, "feed.tmpl.html", gin.H{
-		"posts": posts,
+		"posts":  posts,
+		"cursor": nextCursor(posts, pageSize),
 	})
 }
 
 // Return feed posts for loading through AJAX
 func LoadMoreFeed(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
-	posts := database.ReadFeedPosts(id.(string), 10, feedLimit)
-	feedLimit += 10
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	posts := store.ReadFeedPosts(id.(string), pageSize, cursor)
 	for index := range posts {
-		author := database.ReadUserById(posts[index].UserId)
+		author := store.ReadUserById(posts[index].UserId)
 		posts[index].Username = author.Username
-		posts[index].Avatar = author.Avatar
+		posts[index].Avatar = author.AvatarSmall
 	}
-	c.JSON(http.StatusOK, posts)
+	c.JSON(http.StatusOK, api.PostPage{
+		Posts:  posts,
+		Cursor: nextCursor(posts, pageSize),
+	})
 }
@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	socials "github.com/Devansh3712/tsuki-go/internal/auth"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// linkedProvider is a row of the identities page
+type linkedProvider struct {
+	socials.ProviderInfo
+	Identity *models.Identity
+}
+
+// loginMethods counts the ways a user can log in: the password, linked
+// provider accounts and passkeys
+func loginMethods(store database.Store, userId string) int {
+	methods := len(store.ReadIdentities(userId)) + len(store.ReadPasskeys(userId))
+	if user := store.ReadUserById(userId); user != nil && user.HasPassword() {
+		methods++
+	}
+	return methods
+}
+
+func Identities(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	linked := make(map[string]models.Identity)
+	for _, identity := range store.ReadIdentities(id.(string)) {
+		linked[identity.Provider] = identity
+	}
+	var providers []linkedProvider
+	for _, info := range socials.Providers() {
+		row := linkedProvider{ProviderInfo: info}
+		if identity, ok := linked[info.Name]; ok {
+			row.Identity = &identity
+			delete(linked, info.Name)
+		}
+		providers = append(providers, row)
+	}
+	// Providers removed from the configuration can still be unlinked
+	for _, identity := range linked {
+		identity := identity
+		providers = append(providers, linkedProvider{
+			ProviderInfo: socials.ProviderInfo{Name: identity.Provider, DisplayName: identity.Provider},
+			Identity:     &identity,
+		})
+	}
+	c.HTML(http.StatusOK, "identities.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
+		"providers": providers,
+	})
+}
+
+// UnlinkIdentity removes a linked provider account, unless it is the last
+// way to log in
+func UnlinkIdentity(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	if loginMethods(store, id.(string)) <= 1 {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "This is the only way to log in to your account, add another one first.",
+		})
+		return
+	}
+	if result := store.DeleteIdentity(id.(string), c.Param("provider")); !result {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Linked account not found.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/identities")
+}
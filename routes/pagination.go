@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+)
+
+const pageSize = 10
+
+func nextCursor[T interface{ Cursor() models.Cursor }](items []T, limit int) string {
+	return internal.NextCursor(items, limit, secretKey)
+}
+
+// pageCursor reads the cursor query parameter of AJAX requests, the first
+// page is returned if it is missing
+func pageCursor(c *gin.Context) (*models.Cursor, bool) {
+	token := c.Query("cursor")
+	if token == "" {
+		return nil, true
+	}
+	cursor, err := internal.DecodeCursor(token, secretKey)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid cursor.",
+		})
+		return nil, false
+	}
+	return cursor, true
+}
@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var errPasskeyNotFound = errors.New("passkey not found")
+
+// The relying party is the host of BASE_URL, passkeys registered for one
+// host can't be used on another
+func newWebAuthn(baseURL string) *webauthn.WebAuthn {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		panic(err)
+	}
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          parsed.Hostname(),
+		RPDisplayName: "Tsuki",
+		RPOrigins:     []string{parsed.Scheme + "://" + parsed.Host},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return webAuthn
+}
+
+// webAuthnUser adapts a user and their passkeys to the webauthn.User
+// interface, the user handle is the user id
+type webAuthnUser struct {
+	user     *models.User
+	passkeys []models.Passkey
+}
+
+func (u webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.Id)
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for index, passkey := range u.passkeys {
+		credentials[index] = passkey.Credential
+	}
+	return credentials
+}
+
+// The ceremony state is kept in the cookie between the begin and finish
+// requests, it is signed so the challenge can't be forged
+func saveCeremony(session sessions.Session, key string, data *webauthn.SessionData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session.Set(key, string(encoded))
+	return session.Save()
+}
+
+func loadCeremony(session sessions.Session, key string) (*webauthn.SessionData, bool) {
+	encoded, ok := session.Get(key).(string)
+	if !ok {
+		return nil, false
+	}
+	session.Delete(key)
+	session.Save()
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(encoded), &data); err != nil {
+		return nil, false
+	}
+	return &data, true
+}
+
+func Passkeys(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	c.HTML(http.StatusOK, "passkeys.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
+		"passkeys":  store.ReadPasskeys(id.(string)),
+	})
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create
+func BeginPasskeyRegistration(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not logged in."})
+		return
+	}
+	user := webAuthnUser{store.ReadUserById(id.(string)), store.ReadPasskeys(id.(string))}
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, data, err := webAuthn.BeginRegistration(
+		user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to register passkey, try again later."})
+		return
+	}
+	if err := saveCeremony(session, "passkeyRegistration", data); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to register passkey, try again later."})
+		return
+	}
+	c.JSON(http.StatusOK, creation)
+}
+
+// FinishPasskeyRegistration verifies the new credential and saves it under
+// the name given in the query
+func FinishPasskeyRegistration(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not logged in."})
+		return
+	}
+	var passkey models.Passkey
+	if err := c.ShouldBindWith(&passkey, binding.Query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data, ok := loadCeremony(session, "passkeyRegistration")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration expired, try again."})
+		return
+	}
+	user := webAuthnUser{store.ReadUserById(id.(string)), store.ReadPasskeys(id.(string))}
+	credential, err := webAuthn.FinishRegistration(user, *data, c.Request)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to verify passkey."})
+		return
+	}
+	passkey.Id = models.PasskeyId(credential.ID)
+	passkey.UserId = id.(string)
+	passkey.Credential = *credential
+	passkey.CreatedAt = time.Now()
+	if result := store.CreatePasskey(&passkey); !result {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey already registered."})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"redirect": "/user/settings/passkeys"})
+}
+
+func DeletePasskey(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	if loginMethods(store, id.(string)) <= 1 {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "This is the only way to log in to your account, add another one first.",
+		})
+		return
+	}
+	if result := store.DeletePasskey(id.(string), c.Param("id")); !result {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Passkey not found.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/passkeys")
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get, no
+// username is needed as passkeys carry the user handle
+func BeginPasskeyLogin(c *gin.Context) {
+	session := sessions.Default(c)
+	assertion, data, err := webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to log in, try again later."})
+		return
+	}
+	if err := saveCeremony(session, "passkeyLogin", data); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to log in, try again later."})
+		return
+	}
+	c.JSON(http.StatusOK, assertion)
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session. A passkey
+// with user verification is already two factors, so TOTP is not asked, but
+// a locked account stays locked.
+func FinishPasskeyLogin(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	data, ok := loadCeremony(session, "passkeyLogin")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey login expired, try again."})
+		return
+	}
+	response, err := protocol.ParseCredentialRequestResponse(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response."})
+		return
+	}
+	var passkey *models.Passkey
+	credential, err := webAuthn.ValidateDiscoverableLogin(func(rawId, userHandle []byte) (webauthn.User, error) {
+		passkey = store.ReadPasskey(models.PasskeyId(rawId))
+		if passkey == nil || passkey.UserId != string(userHandle) {
+			return nil, errPasskeyNotFound
+		}
+		user := store.ReadUserById(passkey.UserId)
+		if user == nil {
+			return nil, errPasskeyNotFound
+		}
+		return webAuthnUser{user, []models.Passkey{*passkey}}, nil
+	}, *data, response)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown or invalid passkey."})
+		return
+	}
+	// A sign count going backwards means the authenticator was cloned
+	if credential.Authenticator.CloneWarning {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey rejected, it may have been copied."})
+		return
+	}
+	if locked, retryAfter := middleware.LockedOut(c, passkey.UserId); locked {
+		middleware.SetRetryAfter(c, retryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later."})
+		return
+	}
+	store.TouchPasskey(passkey.Id, *credential, time.Now())
+	if err := middleware.StartSession(c, passkey.UserId); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to log in, try again later."})
+		return
+	}
+	middleware.LoginSucceeded(c, passkey.UserId)
+	c.JSON(http.StatusOK, gin.H{"redirect": "/feed"})
+}
@@ -0,0 +1,368 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// Flags of the authenticator data: user present, user verified and
+// attested credential data included
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softAuthenticator is a passkey kept in memory, it signs with a P-256 key
+// and reports the sign count it is told to
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialId := make([]byte, 16)
+	rand.Read(credentialId)
+	return &softAuthenticator{key: key, credentialId: credentialId}
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    webAuthn.Config.RPOrigins[0],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(webAuthn.Config.RPID))
+	data := append(rpIdHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// create answers navigator.credentials.create with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, options creationOptions) any {
+	t.Helper()
+	a.userHandle = options.PublicKey.User.Id
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified | flagAttestedData)
+	// An all zero AAGUID, followed by the credential id and public key
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialId)))
+	authData = append(authData, a.credentialId...)
+	authData = append(authData, publicKey...)
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]any{
+		"id":    encode(a.credentialId),
+		"rawId": encode(a.credentialId),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(a.clientData(t, "webauthn.create", options.PublicKey.Challenge)),
+			"attestationObject": encode(attestation),
+		},
+	}
+}
+
+// get answers navigator.credentials.get for the challenge
+func (a *softAuthenticator) get(t *testing.T, options requestOptions) any {
+	t.Helper()
+	clientData := a.clientData(t, "webauthn.get", options.PublicKey.Challenge)
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]any{
+		"id":    encode(a.credentialId),
+		"rawId": encode(a.credentialId),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(a.userHandle),
+		},
+	}
+}
+
+type creationOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		User      struct {
+			Id base64URL `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+type requestOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+	} `json:"publicKey"`
+}
+
+// base64URL decodes a base64url JSON string
+type base64URL []byte
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	*b = decoded
+	return err
+}
+
+// passkeyServer serves the passkey routes with a memory store. GET /login
+// sets the user of the id query in the session, standing in for a password
+// login.
+func passkeyServer(t *testing.T, store database.Store, limits database.RateLimitStore) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.Use(sessions.Sessions("tsuki", cookie.NewStore([]byte("test"))))
+	app.Use(middleware.StoreMiddleware(store))
+	app.Use(middleware.RateLimitStoreMiddleware(limits))
+	app.GET("/login", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("userId", c.Query("id"))
+		session.Save()
+	})
+	app.POST("/passkeys/begin", BeginPasskeyRegistration)
+	app.POST("/passkeys/finish", FinishPasskeyRegistration)
+	app.POST("/auth/passkey/begin", BeginPasskeyLogin)
+	app.POST("/auth/passkey/finish", FinishPasskeyLogin)
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// browser keeps the cookie session of one client
+type browser struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, server *httptest.Server) *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{t, server, &http.Client{Jar: jar}}
+}
+
+// do sends the body as JSON and decodes the JSON response into out
+func (b *browser) do(method string, path string, body any, out any) int {
+	b.t.Helper()
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			b.t.Fatal(err)
+		}
+	}
+	request, _ := http.NewRequest(method, b.server.URL+path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	response, err := b.client.Do(request)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if out != nil {
+		json.NewDecoder(response.Body).Decode(out)
+	}
+	return response.StatusCode
+}
+
+func createUser(t *testing.T, store database.Store, username string) *models.User {
+	t.Helper()
+	email := username + "@example.com"
+	user := &models.User{
+		Email:     &email,
+		Username:  username,
+		Id:        username + "-0000-0000-0000-000000000000",
+		Verified:  true,
+		CreatedAt: time.Now(),
+	}
+	if !store.CreateUser(user) {
+		t.Fatalf("unable to create %s", username)
+	}
+	return user
+}
+
+// registerPasskey runs the registration ceremony for the user
+func registerPasskey(t *testing.T, server *httptest.Server, user *models.User) *softAuthenticator {
+	t.Helper()
+	authenticator := newSoftAuthenticator(t)
+	browser := newBrowser(t, server)
+	browser.do("GET", "/login?id="+user.Id, nil, nil)
+	var options creationOptions
+	if status := browser.do("POST", "/passkeys/begin", nil, &options); status != http.StatusOK {
+		t.Fatalf("begin registration: %d", status)
+	}
+	if string(options.PublicKey.User.Id) != user.Id {
+		t.Fatalf("user handle = %q, want %q", options.PublicKey.User.Id, user.Id)
+	}
+	authenticator.signCount = 1
+	var result map[string]string
+	if status := browser.do("POST", "/passkeys/finish?name=Laptop", authenticator.create(t, options), &result); status != http.StatusCreated {
+		t.Fatalf("finish registration: %d %v", status, result)
+	}
+	return authenticator
+}
+
+// login runs the login ceremony and returns the status of the finish
+// request with the assertion that was sent
+func login(t *testing.T, browser *browser, authenticator *softAuthenticator) (int, any) {
+	t.Helper()
+	var options requestOptions
+	if status := browser.do("POST", "/auth/passkey/begin", nil, &options); status != http.StatusOK {
+		t.Fatalf("begin login: %d", status)
+	}
+	authenticator.signCount++
+	assertion := authenticator.get(t, options)
+	return browser.do("POST", "/auth/passkey/finish", assertion, nil), assertion
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := passkeyServer(t, store, database.NewMemoryRateLimitStore())
+	user := createUser(t, store, "alice")
+	authenticator := registerPasskey(t, server, user)
+	if passkeys := store.ReadPasskeys(user.Id); len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+		t.Fatalf("passkeys = %+v", passkeys)
+	}
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusOK {
+		t.Fatalf("login: %d", status)
+	}
+	passkey := store.ReadPasskeys(user.Id)[0]
+	if passkey.LastUsedAt == nil || passkey.Credential.Authenticator.SignCount != authenticator.signCount {
+		t.Errorf("passkey not touched: %+v", passkey)
+	}
+}
+
+func TestPasskeyLoginWrongUserHandle(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := passkeyServer(t, store, database.NewMemoryRateLimitStore())
+	authenticator := registerPasskey(t, server, createUser(t, store, "alice"))
+	authenticator.userHandle = []byte(createUser(t, store, "bob").Id)
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusUnauthorized {
+		t.Fatalf("login with another user handle: %d, want 401", status)
+	}
+}
+
+func TestPasskeyLoginReplay(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := passkeyServer(t, store, database.NewMemoryRateLimitStore())
+	authenticator := registerPasskey(t, server, createUser(t, store, "alice"))
+	browser := newBrowser(t, server)
+	status, assertion := login(t, browser, authenticator)
+	if status != http.StatusOK {
+		t.Fatalf("login: %d", status)
+	}
+	// The challenge is used up with the ceremony
+	if status := browser.do("POST", "/auth/passkey/finish", assertion, nil); status != http.StatusBadRequest {
+		t.Errorf("replay without a ceremony: %d, want 400", status)
+	}
+	// A new ceremony has a new challenge
+	var options requestOptions
+	browser.do("POST", "/auth/passkey/begin", nil, &options)
+	if status := browser.do("POST", "/auth/passkey/finish", assertion, nil); status != http.StatusUnauthorized {
+		t.Errorf("replay in a new ceremony: %d, want 401", status)
+	}
+}
+
+func TestPasskeyLoginCloneWarning(t *testing.T) {
+	store := database.NewMemoryStore()
+	server := passkeyServer(t, store, database.NewMemoryRateLimitStore())
+	authenticator := registerPasskey(t, server, createUser(t, store, "alice"))
+	authenticator.signCount = 10
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusOK {
+		t.Fatalf("login: %d", status)
+	}
+	// A copy of the key still counting from before
+	authenticator.signCount = 4
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusUnauthorized {
+		t.Fatalf("login with a lower sign count: %d, want 401", status)
+	}
+}
+
+func TestPasskeyLoginLockout(t *testing.T) {
+	store, limits := database.NewMemoryStore(), database.NewMemoryRateLimitStore()
+	server := passkeyServer(t, store, limits)
+	user := createUser(t, store, "alice")
+	authenticator := registerPasskey(t, server, user)
+
+	now := time.Now()
+	limits.RecordLoginFailure(user.Id, now, time.Hour)
+	if !limits.LockUser(user.Id, now, now.Add(time.Hour)) {
+		t.Fatal("unable to lock user")
+	}
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusTooManyRequests {
+		t.Errorf("login of a locked account: %d, want 429", status)
+	}
+
+	// A passkey login clears the failed password logins
+	limits.DeleteLockout(user.Id)
+	limits.RecordLoginFailure(user.Id, now, time.Hour)
+	if status, _ := login(t, newBrowser(t, server), authenticator); status != http.StatusOK {
+		t.Fatalf("login: %d", status)
+	}
+	if lockout := limits.ReadLockout(user.Id); lockout != nil {
+		t.Errorf("failures kept after login: %+v", lockout)
+	}
+}
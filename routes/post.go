@@ -1,10 +1,18 @@
 package routes
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Devansh3712/tsuki-go/api"
 	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/Devansh3712/tsuki-go/middleware"
 	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -12,9 +20,105 @@ import (
 	"github.com/google/uuid"
 )
 
-var commentLimit = 10
+const (
+	// Posts attach at most this many images
+	maxAttachments = 4
+	maxAltLength   = 500
+)
+
+// upload is an image read from the form, encoded but not stored yet
+type upload struct {
+	media models.Media
+	data  []byte
+}
+
+// readAttachments processes the images of the form fields media0 to media3,
+// with their alt texts in alt0 to alt3. An error page is rendered if an
+// image can't be used.
+func readAttachments(c *gin.Context) ([]upload, bool) {
+	var uploads []upload
+	for index := 0; index < maxAttachments; index++ {
+		upload, status, message := readAttachment(c, index)
+		if status == http.StatusNoContent {
+			continue
+		}
+		if status != http.StatusOK {
+			c.HTML(status, "error.tmpl.html", gin.H{
+				"error":   fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				"message": message,
+			})
+			return nil, false
+		}
+		upload.media.Position = len(uploads)
+		uploads = append(uploads, upload)
+	}
+	return uploads, true
+}
+
+// readAttachment encodes the image of a form field. Like avatars it is
+// re-encoded, which drops its metadata, and scaled down. The status is 204
+// if the field is empty.
+func readAttachment(c *gin.Context, index int) (upload, int, string) {
+	file, header, err := c.Request.FormFile(fmt.Sprintf("media%d", index))
+	if err == http.ErrMissingFile {
+		return upload{}, http.StatusNoContent, ""
+	}
+	if err != nil {
+		return upload{}, http.StatusBadRequest, "Unable to process request, try again later."
+	}
+	defer file.Close()
+	alt := strings.TrimSpace(c.PostForm(fmt.Sprintf("alt%d", index)))
+	if len([]rune(alt)) > maxAltLength {
+		return upload{}, http.StatusBadRequest, fmt.Sprintf("Alt texts must be at most %d characters.", maxAltLength)
+	}
+	if header.Size > media.MaxImageSize {
+		return upload{}, http.StatusRequestEntityTooLarge, "Images must be smaller than 10 MB."
+	}
+	fileData, err := io.ReadAll(file)
+	if err != nil {
+		return upload{}, http.StatusBadRequest, "Unable to read image, try again later."
+	}
+	img, orientation, err := media.DecodeImage(fileData)
+	if err == media.ErrImageTooLarge {
+		return upload{}, http.StatusRequestEntityTooLarge, "Images must be smaller than 10 MB and 25 megapixels."
+	} else if err != nil {
+		return upload{}, http.StatusBadRequest, "Images must be JPEG, PNG, GIF or WebP images."
+	}
+	data, contentType, err := media.EncodeImage(media.Orient(media.Fit(img, media.PostImageSide), orientation))
+	if err != nil {
+		log.Println(err)
+		return upload{}, http.StatusInternalServerError, "Unable to process image, try again later."
+	}
+	key, _ := media.Key("posts", contentType, data)
+	return upload{
+		media: models.Media{Key: key, ContentType: contentType, Alt: alt},
+		data:  data,
+	}, http.StatusOK, ""
+}
+
+// uploadAttachments stores the blobs of the images, the caller holds the
+// lock on their keys. The media already stored are returned with an error.
+func uploadAttachments(c *gin.Context, uploads []upload) ([]models.Media, error) {
+	blobs := media.Default(c)
+	var attachments []models.Media
+	for _, upload := range uploads {
+		url, err := blobs.Put(c.Request.Context(), upload.media.Key, upload.media.ContentType, upload.data)
+		if err != nil {
+			return attachments, err
+		}
+		upload.media.URL = url
+		attachments = append(attachments, upload.media)
+	}
+	return attachments, nil
+}
+
+// deleteAttachments removes the blobs of the media no post attaches anymore
+func deleteAttachments(c *gin.Context, attachments []models.Media) {
+	media.DeleteUnused(c, attachments, database.Default(c))
+}
 
 func NewPost(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -26,7 +130,10 @@ func NewPost(c *gin.Context) {
 	}
 	switch c.Request.Method {
 	case "GET":
-		c.HTML(http.StatusOK, "makePost.tmpl.html", nil)
+		c.HTML(http.StatusOK, "makePost.tmpl.html", gin.H{
+			"csrfToken":   middleware.CSRFToken(c),
+			"attachments": make([]int, maxAttachments),
+		})
 	case "POST":
 		var post models.Post
 		if err := c.Request.ParseForm(); err != nil {
@@ -43,9 +150,42 @@ func NewPost(c *gin.Context) {
 			})
 			return
 		}
+		uploads, ok := readAttachments(c)
+		if !ok {
+			return
+		}
+		// The blobs can't be deleted from the upload until the post
+		// attaches them
+		keys := make([]string, len(uploads))
+		for i, upload := range uploads {
+			keys[i] = upload.media.Key
+		}
+		unlock, ok := store.LockMedia(keys)
+		if !ok {
+			c.HTML(http.StatusServiceUnavailable, "error.tmpl.html", gin.H{
+				"error":   "503 Service Unavailable",
+				"message": "Unable to create post, try again later.",
+			})
+			return
+		}
+		attachments, err := uploadAttachments(c, uploads)
+		if err != nil {
+			unlock()
+			log.Println(err)
+			deleteAttachments(c, attachments)
+			c.HTML(http.StatusBadGateway, "error.tmpl.html", gin.H{
+				"error":   "502 Bad Gateway",
+				"message": "Unable to upload image, try again later.",
+			})
+			return
+		}
 		post.Id = uuid.NewString()
+		post.Media = attachments
 		post.CreatedAt = time.Now()
-		if result := database.CreatePost(id.(string), &post); !result {
+		result := store.CreatePost(id.(string), &post)
+		unlock()
+		if !result {
+			deleteAttachments(c, attachments)
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
 				"message": "Unable to create post, try again later.",
@@ -56,12 +196,77 @@ func NewPost(c *gin.Context) {
 	}
 }
 
+// EditPost replaces the body of a post within the edit window after posting,
+// the previous body is kept in the history of the post
+func EditPost(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	post := store.ReadPost(c.Param("id"))
+	if post == nil {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Post not found or doesn't exist.",
+		})
+		return
+	}
+	if id.(string) != post.UserId {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "Cannot perform this task.",
+		})
+		return
+	}
+	if !post.Editable(internal.PostEditWindow(), time.Now()) {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "This post can no longer be edited.",
+		})
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "makePost.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"post":      post,
+		})
+	case "POST":
+		var edit models.Post
+		if err := c.ShouldBindWith(&edit, binding.Form); err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": err.Error(),
+			})
+			return
+		}
+		// Saving without changes doesn't add a revision
+		if edit.Body != post.Body {
+			if result := store.UpdatePost(post.Id, edit.Body, uuid.NewString(), time.Now()); !result {
+				c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+					"error":   "400 Bad Request",
+					"message": "Unable to edit post, try again later.",
+				})
+				return
+			}
+		}
+		c.Redirect(http.StatusFound, "/post/"+post.Id)
+	}
+}
+
 func GetPost(c *gin.Context) {
-	var self, voted bool
+	store := database.Default(c)
+	var self, voted, editable bool
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	postId := c.Param("id")
-	post := database.ReadPost(postId)
+	post := store.ReadPost(postId)
 	if post == nil {
 		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 			"error":   "404 Not Found",
@@ -69,10 +274,9 @@ func GetPost(c *gin.Context) {
 		})
 		return
 	}
-	commentLimit = 10
-	comments := database.ReadComments(post.Id, 10, 0)
+	comments := store.ReadComments(post.Id, pageSize, nil)
 	for index := range comments {
-		comments[index].Username = database.ReadUserById(comments[index].UserId).Username
+		comments[index].Username = store.ReadUserById(comments[index].UserId).Username
 		// Enable delete comment if its current user's comment
 		if id != nil && id.(string) == comments[index].UserId {
 			comments[index].Self = true
@@ -80,40 +284,53 @@ func GetPost(c *gin.Context) {
 	}
 	if id != nil {
 		// Check if current user has voted on post
-		voted = database.Voted(id.(string), post.Id)
+		voted = store.Voted(id.(string), post.Id)
 		// Enable delete post if its current user's post
 		if id.(string) == post.UserId {
 			self = true
+			editable = post.Editable(internal.PostEditWindow(), time.Now())
 		}
 	}
 	c.HTML(http.StatusOK, "getPost.tmpl.html", gin.H{
-		"author":   database.ReadUserById(post.UserId),
-		"post":     post,
-		"self":     self,
-		"voted":    voted,
-		"voters":   database.ReadVotes(post.Id),
-		"comments": comments,
+		"csrfToken": middleware.CSRFToken(c),
+		"author":    store.ReadUserById(post.UserId),
+		"post":      post,
+		"self":      self,
+		"editable":  editable,
+		"voted":     voted,
+		"voters":    store.ReadVotes(post.Id),
+		"revisions": store.ReadRevisions(post.Id),
+		"comments":  comments,
+		"cursor":    nextCursor(comments, pageSize),
 	})
 }
 
 // Return comments for loading through AJAX
 func LoadMoreComments(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	postId := c.Param("id")
-	comments := database.ReadComments(postId, 10, commentLimit)
-	commentLimit += 10
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	comments := store.ReadComments(postId, pageSize, cursor)
 	for index := range comments {
-		comments[index].Username = database.ReadUserById(comments[index].UserId).Username
+		comments[index].Username = store.ReadUserById(comments[index].UserId).Username
 		// Enable delete comment if its current user's comment
 		if id != nil && id.(string) == comments[index].UserId {
 			comments[index].Self = true
 		}
 	}
-	c.JSON(http.StatusOK, comments)
+	c.JSON(http.StatusOK, api.CommentPage{
+		Comments: comments,
+		Cursor:   nextCursor(comments, pageSize),
+	})
 }
 
 func DeletePost(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -124,7 +341,14 @@ func DeletePost(c *gin.Context) {
 		return
 	}
 	postId := c.Param("id")
-	post := database.ReadPost(postId)
+	post := store.ReadPost(postId)
+	if post == nil {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Post not found or doesn't exist.",
+		})
+		return
+	}
 	if id.(string) != post.UserId {
 		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
 			"error":   "401 Unauthorized",
@@ -132,19 +356,22 @@ func DeletePost(c *gin.Context) {
 		})
 		return
 	}
-	if result := database.DeletePost(post.Id); !result {
+	if result := store.DeletePost(post.Id); !result {
 		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 			"error":   "400 Bad Request",
 			"message": "Unable to delete post, try again later.",
 		})
 		return
 	}
+	// The media rows went with the post
+	deleteAttachments(c, post.Media)
 	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
 		"message": "Post deleted successfully.",
 	})
 }
 
 func ToggleVote(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -155,11 +382,12 @@ func ToggleVote(c *gin.Context) {
 		return
 	}
 	postId := c.Param("id")
-	database.ToggleVote(id.(string), postId)
+	store.ToggleVote(id.(string), postId)
 	c.Redirect(http.StatusFound, "/post/"+postId)
 }
 
 func Comment(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -187,7 +415,7 @@ func Comment(c *gin.Context) {
 	postId := c.Param("id")
 	comment.Id = uuid.NewString()
 	comment.CreatedAt = time.Now()
-	if result := database.CreateComment(id.(string), postId, &comment); !result {
+	if result := store.CreateComment(id.(string), postId, &comment); !result {
 		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 			"error":   "400 Bad Request",
 			"message": "Unable to add comment, try again later.",
@@ -198,6 +426,7 @@ func Comment(c *gin.Context) {
 }
 
 func DeleteComment(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -208,8 +437,8 @@ func DeleteComment(c *gin.Context) {
 		return
 	}
 	postId := c.Param("id")
-	commentId := c.Query("commentId")
-	comment := database.ReadComment(commentId)
+	commentId := c.PostForm("commentId")
+	comment := store.ReadComment(commentId)
 	if comment == nil {
 		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 			"error":   "404 Not Found",
@@ -224,7 +453,7 @@ func DeleteComment(c *gin.Context) {
 		})
 		return
 	}
-	if result := database.DeleteComment(commentId); !result {
+	if result := store.DeleteComment(commentId); !result {
 		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 			"error":   "400 Bad Request",
 			"message": "Unable to delete comment, try again later.",
@@ -0,0 +1,132 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Reset links are short-lived as they give access to the account
+const resetTokenLifetime = time.Hour
+
+// sendPasswordLink mails the user a link to reset their password, or to
+// set one if the account was created through a login provider
+func sendPasswordLink(c *gin.Context, user *models.User) bool {
+	store := database.Default(c)
+	resetToken, err := createMailedToken(middleware.JWTClaims{
+		UserId:  user.Id,
+		Purpose: middleware.PurposeReset,
+	}, resetTokenLifetime)
+	if err != nil {
+		return false
+	}
+	resetId := uuid.NewString()
+	if result := store.CreateVerificationId(resetToken, resetId, user.Id, middleware.PurposeReset); !result {
+		return false
+	}
+	name := "reset"
+	if !user.HasPassword() {
+		name = "password"
+	}
+	return queueMail(c, name, *user.Email, gin.H{
+		"username": user.Username,
+		"link":     fmt.Sprintf("%s/auth/reset/%s", baseURL, resetId),
+	}, "reset:"+user.Id)
+}
+
+// ForgotPassword mails a reset link. The response is the same whether or
+// not an account uses the email, and the lookup and mail happen after the
+// response so its time doesn't tell either. The route is rate limited per
+// client and per email before the lookup is started.
+func ForgotPassword(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "reset.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "forgot",
+		})
+	case "POST":
+		email := c.PostForm("email")
+		// The copy keeps the stores and mailer once the request is done
+		background := c.Copy()
+		go func() {
+			if user := database.Default(background).ReadUserByEmail(email); user != nil {
+				sendPasswordLink(background, user)
+			}
+		}()
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": "If an account uses this email, a password reset link has been sent to it.",
+		})
+	}
+}
+
+// ResetPassword sets a new password through a mailed link. The link works
+// once, every session and personal access token of the account is revoked
+// and a lockout is lifted.
+func ResetPassword(c *gin.Context) {
+	store := database.Default(c)
+	resetId := c.Param("id")
+	claims := readMailedToken(store, resetId, middleware.PurposeReset)
+	if claims == nil {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Reset link is invalid or has expired, request a new one.",
+		})
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "reset.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "reset",
+			"id":        resetId,
+		})
+	case "POST":
+		password := c.PostForm("password")
+		if password == "" {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Password is required.",
+			})
+			return
+		}
+		// Only the request that deletes the link may use it
+		if result := store.DeleteVerificationId(resetId); !result {
+			c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+				"error":   "404 Not Found",
+				"message": "Reset link is invalid or has expired, request a new one.",
+			})
+			return
+		}
+		user := store.ReadUserById(claims.UserId)
+		if user == nil {
+			c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+				"error":   "404 Not Found",
+				"message": "User does not exist.",
+			})
+			return
+		}
+		user.Password = password
+		user.HashPassword()
+		if result := store.UpdateUser(user.Id, map[string]any{"password": user.Password}); !result {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to reset password, try again later.",
+			})
+			return
+		}
+		store.RevokeSessions(user.Id, "")
+		store.DeleteAccessTokens(user.Id)
+		// The owner proved access to the mail, lift a lockout
+		middleware.LoginSucceeded(c, user.Id)
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": "Password reset successfully, log in with the new password.",
+		})
+	}
+}
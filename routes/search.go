@@ -3,80 +3,65 @@ package routes
 import (
 	"net/http"
 
+	"github.com/Devansh3712/tsuki-go/api"
 	"github.com/Devansh3712/tsuki-go/database"
-	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/Devansh3712/tsuki-go/middleware"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-var searchLimit = 10
-
-type search struct {
-	models.User
-	Followers int
-	Following int
-	Posts     int
-	Follows   any
-}
-
 func SearchUser(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	switch c.Request.Method {
 	case "GET":
-		searchLimit = 10
 		session.Delete("search")
 		session.Save()
-		c.HTML(http.StatusOK, "search.tmpl.html", nil)
+		c.HTML(http.StatusOK, "search.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+		})
 	case "POST":
-		id := session.Get("userId")
+		viewerId, _ := session.Get("userId").(string)
 		if c.PostForm("search") != "" {
 			session.Set("search", c.PostForm("search"))
 			session.Save()
 		}
-		keyword := session.Get("search").(string)
-		searchLimit = 10
-		searchResult := database.ReadUsers(keyword, 10, 0)
-		var users []search
+		keyword, _ := session.Get("search").(string)
+		searchResult := store.ReadUsers(keyword, pageSize, nil)
+		var users []api.Profile
 		for _, result := range searchResult {
-			user := search{
-				User:      result,
-				Followers: database.ReadFollowersCount(result.Id),
-				Following: database.ReadFollowingCount(result.Id),
-				Posts:     database.ReadPostsCount(result.Id),
-			}
-			if id != nil && id.(string) != result.Id {
-				user.Follows = database.Followed(id.(string), result.Id)
-			}
-			users = append(users, user)
+			users = append(users, api.NewProfile(store, viewerId, result))
 		}
-		c.JSON(http.StatusOK, users)
+		c.JSON(http.StatusOK, api.ProfilePage{
+			Users:  users,
+			Cursor: nextCursor(searchResult, pageSize),
+		})
 	}
 }
 
 // Return users for loading through AJAX
 func LoadMoreUsers(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
-	id := session.Get("userId")
-	keyword := session.Get("search").(string)
-	searchResult := database.ReadUsers(keyword, 10, searchLimit)
-	searchLimit += 10
-	var users []search
+	viewerId, _ := session.Get("userId").(string)
+	keyword, _ := session.Get("search").(string)
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	searchResult := store.ReadUsers(keyword, pageSize, cursor)
+	var users []api.Profile
 	for _, result := range searchResult {
-		user := search{
-			User:      result,
-			Followers: database.ReadFollowersCount(result.Id),
-			Following: database.ReadFollowingCount(result.Id),
-			Posts:     database.ReadPostsCount(result.Id),
-		}
-		if id != nil && id.(string) != result.Id {
-			user.Follows = database.Followed(id.(string), result.Id)
-		}
-		users = append(users, user)
+		users = append(users, api.NewProfile(store, viewerId, result))
 	}
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, api.ProfilePage{
+		Users:  users,
+		Cursor: nextCursor(searchResult, pageSize),
+	})
 }
 
 func ToggleSearchFollow(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -87,6 +72,6 @@ func ToggleSearchFollow(c *gin.Context) {
 		return
 	}
 	username := c.Param("username")
-	toFollow := database.ReadUserByName(username)
-	database.ToggleFollow(id.(string), toFollow.Id)
+	toFollow := store.ReadUserByName(username)
+	store.ToggleFollow(id.(string), toFollow.Id)
 }
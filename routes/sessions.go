@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+func Sessions(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	c.HTML(http.StatusOK, "sessions.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
+		"sessions":  store.ReadSessions(id.(string)),
+		"current":   session.Get("sessionId"),
+	})
+}
+
+func RevokeSession(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	sessionId := c.Param("id")
+	if result := store.RevokeSession(id.(string), sessionId); !result {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Session not found.",
+		})
+		return
+	}
+	// Revoking the current session logs the user out
+	if sessionId == session.Get("sessionId") {
+		middleware.EndSession(c)
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": "Logged out successfully.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/sessions")
+}
+
+func RevokeOtherSessions(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	current, _ := session.Get("sessionId").(string)
+	if result := store.RevokeSessions(id.(string), current); !result {
+		c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+			"error":   "500 Internal Server Error",
+			"message": "Unable to sign out of other devices, try again later.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/sessions")
+}
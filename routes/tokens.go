@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+)
+
+func validScope(scope string) bool {
+	for _, valid := range models.Scopes {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func AccessTokens(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "tokens.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"tokens":    store.ReadAccessTokens(id.(string)),
+			"scopes":    models.Scopes,
+		})
+	case "POST":
+		var token models.AccessToken
+		if err := c.Request.ParseForm(); err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to parse form.",
+			})
+			return
+		}
+		if err := c.ShouldBindWith(&token, binding.Form); err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": err.Error(),
+			})
+			return
+		}
+		for _, scope := range c.PostFormArray("scopes") {
+			if !validScope(scope) {
+				c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+					"error":   "400 Bad Request",
+					"message": "Unknown scope " + scope + ".",
+				})
+				return
+			}
+			token.Scopes = append(token.Scopes, scope)
+		}
+		if len(token.Scopes) == 0 {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Select at least one scope.",
+			})
+			return
+		}
+		// The token is only shown once, only its hash is stored
+		secret := internal.NewToken(middleware.AccessTokenPrefix)
+		token.Id = uuid.NewString()
+		token.UserId = id.(string)
+		token.Hash = internal.HashToken(secret)
+		token.CreatedAt = time.Now()
+		if result := store.CreateAccessToken(&token); !result {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to create token, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "tokens.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"tokens":    store.ReadAccessTokens(id.(string)),
+			"scopes":    models.Scopes,
+			"created":   secret,
+		})
+	}
+}
+
+func RevokeAccessToken(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	if result := store.DeleteAccessToken(id.(string), c.Param("id")); !result {
+		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
+			"error":   "404 Not Found",
+			"message": "Token not found.",
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, "/user/settings/tokens")
+}
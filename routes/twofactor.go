@@ -0,0 +1,214 @@
+package routes
+
+import (
+	"encoding/base64"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/internal"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+const recoveryCodesCount = 10
+
+// Returns the new recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string) {
+	codes := internal.NewRecoveryCodes(recoveryCodesCount)
+	hashes := make([]string, len(codes))
+	for index, code := range codes {
+		hashes[index] = internal.HashToken(code)
+	}
+	return codes, hashes
+}
+
+// Second step of the login for users with two-factor authentication
+func LoginTwoFactor(c *gin.Context) {
+	if !middleware.PendingLogin(c) {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "twofactor.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "login",
+		})
+	case "POST":
+		if locked, retryAfter := middleware.LockedOut(c, middleware.PendingUserId(c)); locked {
+			middleware.TooManyRequests(c, retryAfter)
+			return
+		}
+		switch err := middleware.FinishLogin(c, c.PostForm("code")); err {
+		case nil:
+			c.Redirect(http.StatusFound, "/feed")
+		case middleware.ErrTwoFactorExpired:
+			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+				"error":   "401 Unauthorized",
+				"message": "Login expired, log in again.",
+			})
+		case middleware.ErrTwoFactorCode:
+			c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+				"error":   "401 Unauthorized",
+				"message": "Incorrect two-factor code.",
+			})
+		default:
+			log.Println(err)
+			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+				"error":   "500 Internal Server Error",
+				"message": "Unable to log in, try again later.",
+			})
+		}
+	}
+}
+
+// TwoFactor shows the enrollment QR code, or the status once enabled. A
+// posted code confirms the enrollment and shows the recovery codes.
+func TwoFactor(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		if middleware.TwoFactorEnabled(store, id.(string)) {
+			c.HTML(http.StatusOK, "twofactor.tmpl.html", gin.H{
+				"csrfToken":     middleware.CSRFToken(c),
+				"type":          "enabled",
+				"recoveryCodes": store.ReadRecoveryCodesCount(id.(string)),
+			})
+			return
+		}
+		// A new secret is generated every time until one is confirmed
+		twoFactor := models.TwoFactor{
+			UserId:    id.(string),
+			Secret:    internal.NewTOTPSecret(),
+			CreatedAt: time.Now(),
+		}
+		if result := store.CreateTwoFactor(&twoFactor); !result {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to set up two-factor authentication, try again later.",
+			})
+			return
+		}
+		user := store.ReadUserById(id.(string))
+		png, err := qrcode.Encode(internal.TOTPURI(twoFactor.Secret, "Tsuki", user.Username), qrcode.Medium, 256)
+		if err != nil {
+			log.Println(err)
+			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+				"error":   "500 Internal Server Error",
+				"message": "Unable to create QR code, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "twofactor.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "enroll",
+			"secret":    twoFactor.Secret,
+			"qrcode":    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		})
+	case "POST":
+		twoFactor := store.ReadTwoFactor(id.(string))
+		if twoFactor == nil || twoFactor.Enabled {
+			c.Redirect(http.StatusFound, "/user/settings/2fa")
+			return
+		}
+		step, ok := internal.VerifyTOTP(twoFactor.Secret, c.PostForm("code"), time.Now())
+		if !ok {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Incorrect two-factor code, scan the QR code again.",
+			})
+			return
+		}
+		codes, hashes := newRecoveryCodes()
+		if result := store.EnableTwoFactor(id.(string), step, hashes); !result {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to enable two-factor authentication, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "twofactor.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "codes",
+			"codes":     codes,
+		})
+	}
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	if !middleware.CheckTwoFactor(store, id.(string), c.PostForm("code")) {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Incorrect two-factor code.",
+		})
+		return
+	}
+	codes, hashes := newRecoveryCodes()
+	if result := store.ReplaceRecoveryCodes(id.(string), hashes); !result {
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Unable to create recovery codes, try again later.",
+		})
+		return
+	}
+	c.HTML(http.StatusOK, "twofactor.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
+		"type":      "codes",
+		"codes":     codes,
+	})
+}
+
+func DisableTwoFactor(c *gin.Context) {
+	store := database.Default(c)
+	session := sessions.Default(c)
+	id := session.Get("userId")
+	if id == nil {
+		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
+			"error":   "401 Unauthorized",
+			"message": "User not logged in.",
+		})
+		return
+	}
+	if !middleware.CheckTwoFactor(store, id.(string), c.PostForm("code")) {
+		c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+			"error":   "403 Forbidden",
+			"message": "Incorrect two-factor code.",
+		})
+		return
+	}
+	if result := store.DeleteTwoFactor(id.(string)); !result {
+		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+			"error":   "400 Bad Request",
+			"message": "Unable to disable two-factor authentication, try again later.",
+		})
+		return
+	}
+	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+		"message": "Two-factor authentication disabled.",
+	})
+}
@@ -1,22 +1,22 @@
 package routes
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
-	"os"
 
+	"github.com/Devansh3712/tsuki-go/api"
 	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/media"
+	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-var postLimit = 5
-
 func GetUser(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -27,29 +27,32 @@ func GetUser(c *gin.Context) {
 		return
 	}
 	userId := id.(string)
+	user := store.ReadUserById(userId)
 	c.HTML(http.StatusOK, "user.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
 		"settings":  true,
-		"user":      database.ReadUserById(userId),
-		"postCount": database.ReadPostsCount(userId),
-		"followers": database.ReadFollowers(userId),
-		"following": database.ReadFollowing(userId),
-		"posts":     database.ReadPosts(userId, 5, 0),
-		"oauth":     database.IsOAuthUser(userId),
+		"user":      user,
+		"postCount": store.ReadPostsCount(userId),
+		"followers": store.ReadFollowers(userId),
+		"following": store.ReadFollowing(userId),
+		"posts":     store.ReadPosts(userId, 5, nil),
+		"password":  user.HasPassword(),
 	})
 }
 
 func GetUserByName(c *gin.Context) {
+	store := database.Default(c)
 	username := c.Param("username")
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id != nil {
-		user := database.ReadUserById(id.(string))
+		user := store.ReadUserById(id.(string))
 		if username == user.Username {
 			c.Redirect(http.StatusFound, "/user/")
 			return
 		}
 	}
-	user := database.ReadUserByName(username)
+	user := store.ReadUserByName(username)
 	if user == nil {
 		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 			"error":   "404 Not Found",
@@ -58,23 +61,25 @@ func GetUserByName(c *gin.Context) {
 		return
 	}
 	user.Email = nil
-	followers := database.ReadFollowers(user.Id)
-	following := database.ReadFollowing(user.Id)
-	postCount := database.ReadPostsCount(user.Id)
-	posts := database.ReadPosts(user.Id, 5, 0)
+	followers := store.ReadFollowers(user.Id)
+	following := store.ReadFollowing(user.Id)
+	postCount := store.ReadPostsCount(user.Id)
+	posts := store.ReadPosts(user.Id, 5, nil)
 
 	if id != nil {
 		c.HTML(http.StatusOK, "user.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
 			"user":      user,
 			"postCount": postCount,
 			"followers": followers,
 			"following": following,
 			"posts":     posts,
-			"follows":   database.Followed(id.(string), user.Id),
+			"follows":   store.Followed(id.(string), user.Id),
 		})
 		return
 	}
 	c.HTML(http.StatusOK, "user.tmpl.html", gin.H{
+		"csrfToken": middleware.CSRFToken(c),
 		"user":      user,
 		"postCount": postCount,
 		"followers": followers,
@@ -84,8 +89,9 @@ func GetUserByName(c *gin.Context) {
 }
 
 func GetUserPosts(c *gin.Context) {
+	store := database.Default(c)
 	username := c.Param("username")
-	user := database.ReadUserByName(username)
+	user := store.ReadUserByName(username)
 	if user == nil {
 		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 			"error":   "404 Not Found",
@@ -93,24 +99,78 @@ func GetUserPosts(c *gin.Context) {
 		})
 		return
 	}
-	postLimit = 10
-	posts := database.ReadPosts(user.Id, 10, 0)
+	posts := store.ReadPosts(user.Id, pageSize, nil)
 	c.HTML(http.StatusOK, "userPosts.tmpl.html", gin.H{
-		"user":  user,
-		"posts": posts,
+		"user":   user,
+		"posts":  posts,
+		"cursor": nextCursor(posts, pageSize),
 	})
 }
 
 // Return posts for loading through AJAX
 func LoadMorePosts(c *gin.Context) {
+	store := database.Default(c)
 	username := c.Param("username")
-	user := database.ReadUserByName(username)
-	posts := database.ReadPosts(user.Id, 10, postLimit)
-	postLimit += 10
-	c.JSON(http.StatusOK, posts)
+	user := store.ReadUserByName(username)
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found.",
+		})
+		return
+	}
+	cursor, ok := pageCursor(c)
+	if !ok {
+		return
+	}
+	posts := store.ReadPosts(user.Id, pageSize, cursor)
+	c.JSON(http.StatusOK, api.PostPage{
+		Posts:  posts,
+		Cursor: nextCursor(posts, pageSize),
+	})
+}
+
+// Avatar sizes in the order they are uploaded, by the column of their URL
+var avatarSizes = []struct {
+	column string
+	size   int
+}{
+	{"avatar", media.AvatarLarge},
+	{"avatar_medium", media.AvatarMedium},
+	{"avatar_small", media.AvatarSmall},
+}
+
+// deleteAvatars removes the blobs of avatar URLs no user shows anymore,
+// users uploading the same image share its blobs
+func deleteAvatars(c *gin.Context, urls []string) {
+	store := database.Default(c)
+	var keys, used []string
+	for _, url := range urls {
+		if key, ok := media.KeyOf("avatars", url); ok {
+			keys = append(keys, key)
+			used = append(used, url)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	unlock, ok := store.LockMedia(keys)
+	if !ok {
+		// Keep the blobs if unsure
+		return
+	}
+	defer unlock()
+	for i, key := range keys {
+		if store.AvatarInUse(used[i]) {
+			continue
+		}
+		if err := media.Default(c).Delete(c.Request.Context(), key); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 func UpdateAvatar(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -123,11 +183,12 @@ func UpdateAvatar(c *gin.Context) {
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "update.tmpl.html", gin.H{
-			"type": "avatar",
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "avatar",
 		})
 	case "POST":
 		// Read the image
-		file, _, err := c.Request.FormFile("avatar")
+		file, header, err := c.Request.FormFile("avatar")
 		if err != nil {
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
@@ -136,20 +197,14 @@ func UpdateAvatar(c *gin.Context) {
 			return
 		}
 		defer file.Close()
-		fileData, err := ioutil.ReadAll(file)
-		if err != nil {
-			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-				"error":   "400 Bad Request",
-				"message": "Unable to read image, try again later.",
+		if header.Size > media.MaxImageSize {
+			c.HTML(http.StatusRequestEntityTooLarge, "error.tmpl.html", gin.H{
+				"error":   "413 Payload Too Large",
+				"message": "Avatar must be smaller than 10 MB.",
 			})
 			return
 		}
-		// Post the image to Freeimage API
-		encoded := base64.StdEncoding.EncodeToString(fileData)
-		response, err := http.PostForm(
-			"https://freeimage.host/api/1/upload?key="+os.Getenv("FREEIMAGE_API_KEY")+"&format=json",
-			url.Values{"source": {encoded}},
-		)
+		fileData, err := io.ReadAll(file)
 		if err != nil {
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
@@ -157,27 +212,85 @@ func UpdateAvatar(c *gin.Context) {
 			})
 			return
 		}
-		defer response.Body.Close()
-		var responseData map[string]interface{}
-		if err := json.NewDecoder(response.Body).Decode(&responseData); err != nil {
+		img, orientation, err := media.DecodeImage(fileData)
+		if err == media.ErrImageTooLarge {
+			c.HTML(http.StatusRequestEntityTooLarge, "error.tmpl.html", gin.H{
+				"error":   "413 Payload Too Large",
+				"message": "Avatar must be smaller than 10 MB and 25 megapixels.",
+			})
+			return
+		} else if err != nil {
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
-				"message": "Unable to upload avatar, try again later.",
+				"message": "Avatar must be a JPEG, PNG, GIF or WebP image.",
+			})
+			return
+		}
+		// Every size is re-encoded, which drops the metadata of the upload
+		uploads := make([]upload, len(avatarSizes))
+		keys := make([]string, len(avatarSizes))
+		for i, avatar := range avatarSizes {
+			data, contentType, err := media.EncodeImage(media.Orient(media.Thumbnail(img, avatar.size), orientation))
+			if err != nil {
+				log.Println(err)
+				c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+					"error":   "500 Internal Server Error",
+					"message": "Unable to process avatar, try again later.",
+				})
+				return
+			}
+			keys[i], _ = media.Key("avatars", contentType, data)
+			uploads[i] = upload{media: models.Media{Key: keys[i], ContentType: contentType}, data: data}
+		}
+		previous := store.ReadUserById(id.(string))
+		// The blobs can't be deleted from the upload until the user shows
+		// them
+		unlock, ok := store.LockMedia(keys)
+		if !ok {
+			c.HTML(http.StatusServiceUnavailable, "error.tmpl.html", gin.H{
+				"error":   "503 Service Unavailable",
+				"message": "Unable to update avatar, try again later.",
 			})
 			return
 		}
-		// Update user avatar URL
-		if result := database.UpdateUser(
-			id.(string),
-			map[string]any{"avatar": responseData["image"].(map[string]interface{})["url"]},
-		); !result {
-			log.Println(responseData)
+		avatars := map[string]any{}
+		var uploaded []string
+		for i, upload := range uploads {
+			url, err := media.Default(c).Put(c.Request.Context(), upload.media.Key, upload.media.ContentType, upload.data)
+			if err != nil {
+				unlock()
+				log.Println(err)
+				deleteAvatars(c, uploaded)
+				c.HTML(http.StatusBadGateway, "error.tmpl.html", gin.H{
+					"error":   "502 Bad Gateway",
+					"message": "Unable to upload avatar, try again later.",
+				})
+				return
+			}
+			uploaded = append(uploaded, url)
+			avatars[avatarSizes[i].column] = url
+		}
+		// Update user avatar URLs
+		result := store.UpdateUser(id.(string), avatars)
+		unlock()
+		if !result {
+			deleteAvatars(c, uploaded)
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
 				"message": "Unable to update avatar, try again later.",
 			})
 			return
 		}
+		// The previous sizes are kept if another user shows them
+		if previous != nil {
+			var urls []string
+			for _, avatar := range []*string{previous.Avatar, previous.AvatarMedium, previous.AvatarSmall} {
+				if avatar != nil {
+					urls = append(urls, *avatar)
+				}
+			}
+			deleteAvatars(c, urls)
+		}
 		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
 			"message": "Avatar updated successfully.",
 		})
@@ -185,6 +298,7 @@ func UpdateAvatar(c *gin.Context) {
 }
 
 func UpdateUsername(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -197,11 +311,12 @@ func UpdateUsername(c *gin.Context) {
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "update.tmpl.html", gin.H{
-			"type": "username",
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "username",
 		})
 	case "POST":
 		newUsername := c.PostForm("username")
-		user := database.ReadUserById(id.(string))
+		user := store.ReadUserById(id.(string))
 		if user.Username == newUsername {
 			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
 				"error":   "403 Forbidden",
@@ -209,14 +324,14 @@ func UpdateUsername(c *gin.Context) {
 			})
 			return
 		}
-		if exists := database.ReadUserByName(newUsername); exists != nil {
+		if exists := store.ReadUserByName(newUsername); exists != nil {
 			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
 				"error":   "403 Forbidden",
 				"message": "Username not available or already taken.",
 			})
 			return
 		}
-		if result := database.UpdateUser(user.Id, map[string]any{"username": newUsername}); !result {
+		if result := store.UpdateUser(user.Id, map[string]any{"username": newUsername}); !result {
 			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
 				"error":   "500 Internal Server Error",
 				"message": "Unable to change username, try again later.",
@@ -230,6 +345,7 @@ func UpdateUsername(c *gin.Context) {
 }
 
 func UpdatePassword(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -239,14 +355,28 @@ func UpdatePassword(c *gin.Context) {
 		})
 		return
 	}
+	// Accounts without a password confirm setting one through their email
+	if user := store.ReadUserById(id.(string)); !user.HasPassword() {
+		setPassword(c, user)
+		return
+	}
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "update.tmpl.html", gin.H{
-			"type": "password",
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "password",
+			"twoFactor": middleware.TwoFactorEnabled(store, id.(string)),
 		})
 	case "POST":
+		if middleware.TwoFactorEnabled(store, id.(string)) && !middleware.CheckTwoFactor(store, id.(string), c.PostForm("code")) {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Incorrect two-factor code.",
+			})
+			return
+		}
 		newPassword := c.PostForm("password")
-		user := database.ReadUserById(id.(string))
+		user := store.ReadUserById(id.(string))
 		if user.CheckPassword(newPassword) {
 			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
 				"error":   "403 Forbidden",
@@ -257,20 +387,48 @@ func UpdatePassword(c *gin.Context) {
 		// Create hash of new password and update it
 		user.Password = newPassword
 		user.HashPassword()
-		if result := database.UpdateUser(id.(string), map[string]any{"password": user.Password}); !result {
+		if result := store.UpdateUser(id.(string), map[string]any{"password": user.Password}); !result {
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
 				"message": "Unable to change password, try again later.",
 			})
 			return
 		}
+		// Someone who learnt the old password may already be logged in
+		if c.PostForm("logout") != "" {
+			current, _ := session.Get("sessionId").(string)
+			store.RevokeSessions(id.(string), current)
+		}
 		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
 			"message": "Password updated successfully",
 		})
 	}
 }
 
+func setPassword(c *gin.Context, user *models.User) {
+	switch c.Request.Method {
+	case "GET":
+		c.HTML(http.StatusOK, "update.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"type":      "set-password",
+			"email":     *user.Email,
+		})
+	case "POST":
+		if result := sendPasswordLink(c, user); !result {
+			c.HTML(http.StatusInternalServerError, "error.tmpl.html", gin.H{
+				"error":   "500 Internal Server Error",
+				"message": "Unable to send mail, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": fmt.Sprintf("A link to set your password was sent to %s", *user.Email),
+		})
+	}
+}
+
 func DeleteUser(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -283,12 +441,14 @@ func DeleteUser(c *gin.Context) {
 	switch c.Request.Method {
 	case "GET":
 		c.HTML(http.StatusOK, "delete.tmpl.html", gin.H{
-			"oauth": database.IsOAuthUser(id.(string)),
+			"csrfToken": middleware.CSRFToken(c),
+			"password":  store.ReadUserById(id.(string)).HasPassword(),
+			"twoFactor": middleware.TwoFactorEnabled(store, id.(string)),
 		})
 	case "POST":
-		user := database.ReadUserById(id.(string))
-		// Password required for users who didn't sign up through OAuth
-		if !database.IsOAuthUser(user.Id) {
+		user := store.ReadUserById(id.(string))
+		// Password required for users who have one
+		if user.HasPassword() {
 			password := c.PostForm("password")
 			if !user.CheckPassword(password) {
 				c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
@@ -298,13 +458,23 @@ func DeleteUser(c *gin.Context) {
 				return
 			}
 		}
-		if result := database.DeleteUser(user.Id); !result {
+		if middleware.TwoFactorEnabled(store, user.Id) && !middleware.CheckTwoFactor(store, user.Id, c.PostForm("code")) {
+			c.HTML(http.StatusForbidden, "error.tmpl.html", gin.H{
+				"error":   "403 Forbidden",
+				"message": "Incorrect two-factor code.",
+			})
+			return
+		}
+		attachments := store.ReadUserMedia(user.Id)
+		if result := store.DeleteUser(user.Id); !result {
 			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 				"error":   "400 Bad Request",
 				"message": "Unable to delete account, try again later.",
 			})
 			return
 		}
+		// The posts and their media rows went with the account
+		deleteAttachments(c, attachments)
 		session := sessions.Default(c)
 		session.Clear()
 		session.Options(sessions.Options{Path: "/", MaxAge: -1})
@@ -316,6 +486,7 @@ func DeleteUser(c *gin.Context) {
 }
 
 func ToggleFollow(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -326,7 +497,7 @@ func ToggleFollow(c *gin.Context) {
 		return
 	}
 	username := c.Param("username")
-	toFollow := database.ReadUserByName(username)
-	database.ToggleFollow(id.(string), toFollow.Id)
+	toFollow := store.ReadUserByName(username)
+	store.ToggleFollow(id.(string), toFollow.Id)
 	c.Redirect(http.StatusFound, "/user/"+username)
 }
@@ -1,88 +1,88 @@
 package routes
 
 import (
-	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/Devansh3712/tsuki-go/database"
+	"github.com/Devansh3712/tsuki-go/mail"
 	"github.com/Devansh3712/tsuki-go/middleware"
+	"github.com/Devansh3712/tsuki-go/models"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/jordan-wright/email"
-	"golang.org/x/oauth2"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
 )
 
-const verificationMail = `<html>
-<head></head>
-<body
-	style="
-	font-family: 'Courier New', Courier, monospace;
-	padding-left: 15px;
-	padding-top: 10px;
-	"
->
-	<h1>Tsuki</h1>
-	<h2>Account Verification</h2>
-	<p>
-	Hi %s, please confirm that %s is your e-mail address by clicking this link %s within 48 hours.
-	</p>
-</body>
-</html>`
-
 func createVerificationToken(id string) (string, error) {
-	claims := middleware.JWTClaims{
-		UserId: id,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 48).Unix(),
-			IssuedAt:  time.Now().Unix(),
-			Issuer:    issuer,
-		},
+	return createMailedToken(middleware.JWTClaims{
+		UserId:  id,
+		Purpose: middleware.PurposeVerify,
+	}, time.Hour*48)
+}
+
+// createMailedToken signs a token for a link sent by mail, the token is
+// stored under a random id that forms the link
+func createMailedToken(claims middleware.JWTClaims, lifetime time.Duration) (string, error) {
+	claims.StandardClaims = jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(lifetime).Unix(),
+		IssuedAt:  time.Now().Unix(),
+		Issuer:    issuer,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(secretKey)
 }
 
-func oauth2Client() (*http.Client, error) {
-	credentials := oauth2.Config{
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		Scopes:       []string{"https://mail.google.com/"},
-		Endpoint: oauth2.Endpoint{
-			TokenURL: os.Getenv("TOKEN_URI"),
-		},
+// readMailedToken returns the claims of the token stored under a link id,
+// or nil if the link is unknown, used, expired or meant for another purpose
+func readMailedToken(store database.Store, id string, purpose string) *middleware.JWTClaims {
+	token := store.ReadVerificationId(id)
+	if token == "" {
+		return nil
 	}
-	expiry, _ := time.Parse(time.RFC3339, os.Getenv("EXPIRY"))
-	token := oauth2.Token{
-		AccessToken:  os.Getenv("TOKEN"),
-		RefreshToken: os.Getenv("REFRESH_TOKEN"),
-		Expiry:       expiry,
+	claims, err := middleware.ParseToken(store, token)
+	if err != nil || claims.Purpose != purpose {
+		return nil
 	}
-	ctx := context.Background()
-	if !token.Valid() {
-		// Refresh the token
-		refreshedToken, err := credentials.TokenSource(ctx, &token).Token()
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		os.Setenv("TOKEN", refreshedToken.AccessToken)
-		os.Setenv("REFRESH_TOKEN", refreshedToken.RefreshToken)
-		os.Setenv("EXPIRY", refreshedToken.Expiry.String())
-		return credentials.Client(ctx, refreshedToken), nil
+	return claims
+}
+
+// queueMail renders a mail in the language of the request and queues it
+func queueMail(c *gin.Context, name string, to string, data gin.H, dedupKey string) bool {
+	message, err := mail.Default(c).Render(name, c.GetHeader("Accept-Language"), to, data)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return mail.Enqueue(database.Default(c), message, dedupKey)
+}
+
+// sendVerificationLink mails the user a link to verify their email,
+// repeated calls only replace the link of a mail that wasn't sent yet
+func sendVerificationLink(c *gin.Context, user *models.User) bool {
+	store := database.Default(c)
+	verificationToken, err := createVerificationToken(user.Id)
+	if err != nil {
+		return false
+	}
+	verificationId := uuid.NewString()
+	if result := store.CreateVerificationId(verificationToken, verificationId, user.Id, middleware.PurposeVerify); !result {
+		return false
 	}
-	return credentials.Client(ctx, &token), nil
+	return queueMail(c, "verify", *user.Email, gin.H{
+		"username": user.Username,
+		"email":    *user.Email,
+		"link":     fmt.Sprintf("%s/auth/verify/%s", baseURL, verificationId),
+	}, "verify:"+user.Id)
 }
 
+// SendVerificationMail asks to send a verification mail on GET, so that
+// following a link doesn't queue one, and sends it on POST
 func SendVerificationMail(c *gin.Context) {
+	store := database.Default(c)
 	session := sessions.Default(c)
 	id := session.Get("userId")
 	if id == nil {
@@ -92,72 +92,50 @@ func SendVerificationMail(c *gin.Context) {
 		})
 		return
 	}
-	client, err := oauth2Client()
-	if err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to send verification mail, try again later.",
-		})
-		return
-	}
-	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
-	if err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to send verification mail, try again later.",
+	user := store.ReadUserById(id.(string))
+	switch c.Request.Method {
+	case "GET":
+		message := fmt.Sprintf("Send a verification link to %s.", *user.Email)
+		// Check if the request is redirected from signup
+		if c.Query("signup") == "true" {
+			message = "Account created succesfully. " + message
+		}
+		c.HTML(http.StatusOK, "confirm.tmpl.html", gin.H{
+			"csrfToken": middleware.CSRFToken(c),
+			"title":     "Verify Account",
+			"message":   message,
+			"action":    "/auth/verify",
+			"button":    "Send verification mail",
 		})
-		return
-	}
-
-	user := database.ReadUserById(id.(string))
-	verificationToken, _ := createVerificationToken(user.Id)
-	verificationId := uuid.NewString()
-	database.CreateVerificationId(verificationToken, verificationId)
-	message := &email.Email{
-		To:      []string{*user.Email},
-		From:    os.Getenv("EMAIL"),
-		Subject: "Verify your Tsuki account",
-		HTML: []byte(fmt.Sprintf(
-			verificationMail,
-			user.Username,
-			*user.Email,
-			fmt.Sprintf("%s/auth/verify/%s", c.Request.Host, verificationId),
-		)),
-	}
-	byteMessage, _ := message.Bytes()
-	mailContent := gmail.Message{
-		Raw: base64.RawURLEncoding.EncodeToString(byteMessage),
-	}
-	if _, err := service.Users.Messages.Send("me", &mailContent).Do(); err != nil {
-		log.Println(err)
-		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
-			"error":   "400 Bad Request",
-			"message": "Unable to send verification mail, try again later.",
+	case "POST":
+		if result := sendVerificationLink(c, user); !result {
+			c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
+				"error":   "400 Bad Request",
+				"message": "Unable to send verification mail, try again later.",
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
+			"message": fmt.Sprintf("Verification mail sent to %s", *user.Email),
 		})
-		return
 	}
-	response := fmt.Sprintf("Verification mail sent to %s", *user.Email)
-	// Check if the request is redirected from signup
-	if c.Query("signup") == "true" {
-		response = "Account created succesfully. " + response
-	}
-	c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
-		"message": response,
-	})
 }
 
 func Verify(c *gin.Context) {
+	store := database.Default(c)
 	verificationId := c.Param("id")
-	verificationToken := database.ReadVerificationId(verificationId)
+	verificationToken := store.ReadVerificationId(verificationId)
 	if verificationToken == "" {
 		c.HTML(http.StatusNotFound, "error.tmpl.html", gin.H{
 			"error":   "404 Not Found",
-			"message": "Verification token not found in database.",
+			"message": "Verification token not found in store.",
 		})
+		return
+	}
+	parsedToken, err := middleware.ParseToken(store, verificationToken)
+	if err == nil && parsedToken.Purpose != "" && parsedToken.Purpose != middleware.PurposeVerify {
+		err = errors.New("not a verification token")
 	}
-	parsedToken, err := middleware.ParseToken(verificationToken)
 	if err != nil {
 		log.Println(err)
 		c.HTML(http.StatusUnauthorized, "error.tmpl.html", gin.H{
@@ -167,14 +145,14 @@ func Verify(c *gin.Context) {
 		return
 	}
 	userId := parsedToken.UserId
-	user := database.ReadUserById(userId)
+	user := store.ReadUserById(userId)
 	if user.Verified {
 		c.HTML(http.StatusOK, "response.tmpl.html", gin.H{
 			"message": "Account already verified.",
 		})
 		return
 	}
-	if result := database.UpdateUser(userId, map[string]any{"verified": true}); !result {
+	if result := store.UpdateUser(userId, map[string]any{"verified": true}); !result {
 		log.Println(err)
 		c.HTML(http.StatusBadRequest, "error.tmpl.html", gin.H{
 			"error":   "400 Bad Request",
@@ -1,14 +1,32 @@
+// Store the cursor of the next page, or remove the button on the last page
+function updateCursor(cursor) {
+    if (cursor) {
+        $("#more").attr("data-cursor", cursor);
+    } else {
+        $("#more").remove();
+    }
+}
+
+// Render the images attached to a post
+function renderMedia(post) {
+    if (!post.Media || post.Media.length == 0) {
+        return "";
+    }
+    var media = $("<div>").addClass("media");
+    post.Media.forEach(function(item) {
+        media.append($("<img>").attr({ src: item.URL, alt: item.Alt, title: item.Alt, loading: "lazy" }));
+    });
+    return media.prop("outerHTML");
+}
+
 // Load more feed posts
 function loadMoreFeed() {
     $.ajax({
         url: "/feed/more",
         type: "GET",
+        data: { cursor: $("#more").attr("data-cursor") },
         success: function(data) {
-            if (!data) {
-                $("#more").remove()
-                return
-            }
-            data.forEach(function(post) {
+            (data.posts || []).forEach(function(post) {
                 content = `<span class="avatar-small">`;
                 if (post.Avatar) {
                     content += `<img src="${post.Avatar}" />`;
@@ -22,13 +40,12 @@ function loadMoreFeed() {
                 </h3>
                 <a href="/post/${post.Id}">
                     <p>${post.Body}</p>
-                    <p class="separator">${post.CreatedAt}</p>
+                    ${renderMedia(post)}
+                    <p class="separator">${post.CreatedAt}${post.EditedAt ? " (edited)" : ""}</p>
                 </a>`;
                 $("#posts").append(content);
             });
-            if (data.length < 10) {
-                $("#more").remove()
-            }
+            updateCursor(data.cursor);
         },
     });
 }
@@ -38,28 +55,27 @@ function loadMoreComments(postId) {
     $.ajax({
         url: `/post/${postId}/comments`,
         type: "GET",
+        data: { cursor: $("#more").attr("data-cursor") },
         success: function(data) {
-            if (!data) {
-                $("#more").remove()
-                return
-            }
-            data.forEach(function(comment) {
+            (data.comments || []).forEach(function(comment) {
                 content = `
                 <p>${comment.Body}</p>
                 <p class="separator">
                 <a href="/user/${comment.Username}">@${comment.Username}</a> &nbsp;`;
                 if (comment.Self) {
                     content += `
-                    <a href="/post/${postId}/comment/delete?commentId=${comment.Id}">
-                        <i class="fa-regular fa-trash-can"></i> Delete
-                    </a>`;
+                    <form class="inline" action="/post/${postId}/comment/delete" method="POST">
+                        <input type="hidden" name="csrf_token" value="${csrfToken()}" />
+                        <input type="hidden" name="commentId" value="${comment.Id}" />
+                        <button class="link" type="submit">
+                            <i class="fa-regular fa-trash-can"></i> Delete
+                        </button>
+                    </form>`;
                 }
                 content += `</p>`;
                 $("#comments").append(content);
             });
-            if (data.length < 10) {
-                $("#more").remove()
-            }
+            updateCursor(data.cursor);
         },
     });
 }
@@ -69,16 +85,14 @@ function loadMoreUsers() {
     $.ajax({
         url: "/search/more",
         type: "GET",
+        data: { cursor: $("#more").attr("data-cursor") },
         success: function(data) {
-            if (!data) {
-                return
-            }
-            $("#more").remove()
-            data.forEach(function(user) {
+            $("#more").remove();
+            (data.users || []).forEach(function(user) {
                 content = `
                 <span class="avatar-small">`;
-                if (user.Avatar) {
-                    content += `<img src="${user.Avatar}" />`;
+                if (user.AvatarSmall) {
+                    content += `<img src="${user.AvatarSmall}" />`;
                 } else {
                     content += `<img src="/static/images/avatar.jpg" />`;
                 }
@@ -106,9 +120,9 @@ function loadMoreUsers() {
                 </p>`;
                 $("#users").append(content);
             });
-            if (data.length == 10) {
+            if (data.cursor) {
                 content = `
-                <div id="more">
+                <div id="more" data-cursor="${data.cursor}">
                 <h3 style="padding-top: 10px">
                     <a onclick="loadMoreUsers()">
                     <i class="fa-solid fa-circle-chevron-down"></i> More
@@ -126,22 +140,18 @@ function loadMorePosts(username) {
     $.ajax({
         url: `/user/${username}/posts/more`,
         type: "GET",
+        data: { cursor: $("#more").attr("data-cursor") },
         success: function(data) {
-            if (!data) {
-                $("#more").remove()
-                return
-            }
-            data.forEach(function(post) {
+            (data.posts || []).forEach(function(post) {
                 content = `
                 <a href="/post/${post.Id}">
                     <p class="content">${post.Body}</p>
-                    <p class="separator">${post.CreatedAt}</p>
+                    ${renderMedia(post)}
+                    <p class="separator">${post.CreatedAt}${post.EditedAt ? " (edited)" : ""}</p>
                 </a>`
                 $("#posts").append(content);
             });
-            if (data.length < 10) {
-                $("#more").remove()
-            }
+            updateCursor(data.cursor);
         },
     });
 }
@@ -0,0 +1,97 @@
+// WebAuthn sends binary fields as base64url strings, the browser API takes
+// and returns ArrayBuffers
+function bufferDecode(value) {
+    value = value.replace(/-/g, "+").replace(/_/g, "/");
+    return Uint8Array.from(atob(value), function(c) { return c.charCodeAt(0); });
+}
+
+function bufferEncode(buffer) {
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(buffer)))
+        .replace(/\+/g, "-").replace(/\//g, "_").replace(/=/g, "");
+}
+
+function passkeyError(xhr) {
+    var message = (xhr.responseJSON && xhr.responseJSON.error) || "Something went wrong, try again.";
+    $("#passkey-error").text(message);
+}
+
+function postJSON(url, data, success) {
+    $.ajax({
+        url: url,
+        type: "POST",
+        headers: { "X-CSRF-Token": csrfToken() },
+        contentType: "application/json",
+        data: JSON.stringify(data),
+        success: success,
+        error: passkeyError,
+    });
+}
+
+// Register a new passkey for the logged in user
+function registerPasskey() {
+    var name = $("#passkey-name").val();
+    if (!name) {
+        $("#passkey-error").text("Enter a name for the passkey.");
+        return;
+    }
+    $.ajax({
+        url: "/user/settings/passkeys/begin",
+        type: "POST",
+        headers: { "X-CSRF-Token": csrfToken() },
+        error: passkeyError,
+        success: function(options) {
+            var publicKey = options.publicKey;
+            publicKey.challenge = bufferDecode(publicKey.challenge);
+            publicKey.user.id = bufferDecode(publicKey.user.id);
+            (publicKey.excludeCredentials || []).forEach(function(credential) {
+                credential.id = bufferDecode(credential.id);
+            });
+            navigator.credentials.create({ publicKey: publicKey }).then(function(credential) {
+                postJSON("/user/settings/passkeys/finish?name=" + encodeURIComponent(name), {
+                    id: credential.id,
+                    rawId: bufferEncode(credential.rawId),
+                    type: credential.type,
+                    response: {
+                        attestationObject: bufferEncode(credential.response.attestationObject),
+                        clientDataJSON: bufferEncode(credential.response.clientDataJSON),
+                    },
+                }, function(data) {
+                    window.location.href = data.redirect;
+                });
+            }).catch(function(error) {
+                $("#passkey-error").text(error.message);
+            });
+        },
+    });
+}
+
+// Log in with a passkey, the browser lets the user pick the account
+function loginWithPasskey() {
+    $.ajax({
+        url: "/auth/passkey/begin",
+        type: "POST",
+        headers: { "X-CSRF-Token": csrfToken() },
+        error: passkeyError,
+        success: function(options) {
+            var publicKey = options.publicKey;
+            publicKey.challenge = bufferDecode(publicKey.challenge);
+            navigator.credentials.get({ publicKey: publicKey }).then(function(credential) {
+                postJSON("/auth/passkey/finish", {
+                    id: credential.id,
+                    rawId: bufferEncode(credential.rawId),
+                    type: credential.type,
+                    response: {
+                        authenticatorData: bufferEncode(credential.response.authenticatorData),
+                        clientDataJSON: bufferEncode(credential.response.clientDataJSON),
+                        signature: bufferEncode(credential.response.signature),
+                        userHandle: bufferEncode(credential.response.userHandle),
+                    },
+                }, function(data) {
+                    window.location.href = data.redirect;
+                });
+            }).catch(function(error) {
+                $("#passkey-error").text(error.message);
+            });
+        },
+    });
+}
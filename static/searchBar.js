@@ -7,19 +7,20 @@ function loadUsers(str) {
     $.ajax({
         url: "/search",
         type: "POST",
+        headers: { "X-CSRF-Token": csrfToken() },
         data: { search: str },
         success: function(data) {
-            if (!data) {
+            if (!data.users) {
                 div.innerHTML = `
                 <p style="color: rgb(130, 130, 130)">No users found.</p>`;
                 return;
             }
             var content = "";
-            data.forEach(function(user) {
+            data.users.forEach(function(user) {
                 content += `
                 <span class="avatar-small">`;
-                if (user.Avatar) {
-                    content += `<img src="${user.Avatar}" />`;
+                if (user.AvatarSmall) {
+                    content += `<img src="${user.AvatarSmall}" />`;
                 } else {
                     content += `<img src="/static/images/avatar.jpg" />`;
                 }
@@ -46,9 +47,9 @@ function loadUsers(str) {
                         following
                     </p>`;
             });
-            if (data.length == 10) {
+            if (data.cursor) {
                 content += `
-                <div id="more">
+                <div id="more" data-cursor="${data.cursor}">
                 <h3 style="padding-top: 10px">
                     <a onclick="loadMoreUsers()">
                     <i class="fa-solid fa-circle-chevron-down"></i> More
@@ -66,6 +67,7 @@ function toggleFollow(username) {
     $.ajax({
         url: `/search/${username}/toggle-follow`,
         type: "POST",
+        headers: { "X-CSRF-Token": csrfToken() },
         success: function() {
             follows.innerText = follows.innerText == "Unfollow" ? "Follow" : "Unfollow";
         }
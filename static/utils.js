@@ -44,3 +44,9 @@ if (togglePassword != null && password != null) {
         this.classList.toggle("fa-eye-slash");
     });
 }
+
+// Token of the session, scripts send it in the X-CSRF-Token header
+function csrfToken() {
+    var meta = document.querySelector('meta[name="csrf-token"]');
+    return meta != null ? meta.getAttribute("content") : "";
+}